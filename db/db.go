@@ -1,10 +1,8 @@
 package database
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"log"
 	"strings"
 	"time"
 
@@ -12,15 +10,9 @@ import (
 	"github.com/a-sube/go-repos-api/utils"
 	"github.com/go-pg/pg"
 	"github.com/go-pg/pg/orm"
-	"github.com/go-redis/redis"
 )
 
 var (
-	redisClient = redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
-		Password: "",
-		DB:       0,
-	})
 	// DB is database
 	DB = pg.Connect(&pg.Options{
 		User:     utils.DBUSER,
@@ -40,18 +32,69 @@ type Repo struct {
 	AvatarURL       string `json:"avatar_url" sql:",nullable"`
 	Readme          string `json:"readme" sql:",nullable"`
 	Modules         []Repo `json:"modules" pg:"many2many:repo_to_repos,joinFK:module_id,zeroable"`
+	// Edge is set on modules only. It describes how parent repo requires this module.
+	Edge *RepoToRepos `json:"edge,omitempty" sql:"-"`
+	// Depth is set on dependents only. It is a distance to the module.
+	Depth int `json:"depth,omitempty" sql:"-"`
+	// StarGrowth is set on trending repos only. It is stars gained within window.
+	StarGrowth int `json:"star_growth,omitempty" sql:"-"`
+	// Snippet is set on search results only. It is HTML of matched text with
+	// matched words in <mark> tags.
+	Snippet string `json:"snippet,omitempty" sql:"-"`
 }
 
-// RepoToRepos is a many2many table struct
+// RepoToRepos is a many2many table struct. Version, Indirect and Replace
+// come from the repo's go.mod. SeenAt is the last time edge was crawled.
 type RepoToRepos struct {
-	RepoID   int
-	ModuleID int
+	RepoID   int       `json:"repo_id"`
+	ModuleID int       `json:"module_id"`
+	Version  string    `json:"version" sql:",nullable"`
+	Indirect bool      `json:"indirect" sql:",notnull,default:false"`
+	Replace  string    `json:"replace,omitempty" sql:",nullable"`
+	SeenAt   time.Time `json:"seen_at" sql:",nullable"`
+}
+
+// RemovedEdge is a table struct. It is an edge deleted from RepoToRepos
+// because the repo's go.mod doesn't require the module anymore.
+type RemovedEdge struct {
+	ID        int       `json:"-"`
+	RepoID    int       `json:"repo_id" sql:",notnull"`
+	ModuleID  int       `json:"module_id" sql:",notnull"`
+	Version   string    `json:"version" sql:",nullable"`
+	Indirect  bool      `json:"indirect" sql:",notnull,default:false"`
+	Replace   string    `json:"replace,omitempty" sql:",nullable"`
+	SeenAt    time.Time `json:"seen_at" sql:",nullable"`
+	RemovedAt time.Time `json:"removed_at" sql:",notnull"`
+}
+
+// moduleRow is a row selected by modulesTreeQuery: module columns
+// followed by columns of the edge pointing to it and path of ids
+// from the queried repo to the module.
+type moduleRow struct {
+	ID              int
+	Name            string
+	FullName        string
+	StargazersCount int
+	ForksCount      int
+	AvatarURL       string
+	Description     string
+	RepoID          int
+	Version         string
+	Indirect        bool
+	Replace         string
+	SeenAt          time.Time
+	Path            []int `sql:",array"`
 }
 
-// DBResponse is a json response struct
+// DBResponse is a json response struct. Total is set on search only,
+// it is count of all matching repos while Count is count of Items.
+// Suggestion is set on search only if nothing matched the term, it is
+// the most similar repo name and Items are repos with similar names.
 type DBResponse struct {
-	Count int
-	Items []Repo
+	Count      int
+	Total      int    `json:",omitempty"`
+	Suggestion string `json:",omitempty"`
+	Items      []Repo
 }
 
 func init() {
@@ -71,74 +114,9 @@ func init() {
 // 	return DB
 // }
 
-// CreateSchema creates Repo and RepoToRepo tables if not exists.
-func CreateSchema() error {
-	models := []interface{}{
-		(*Repo)(nil),
-		(*RepoToRepos)(nil),
-	}
-	for _, model := range models {
-		err := DB.CreateTable(model, &orm.CreateTableOptions{
-			// Temp: true,
-			IfNotExists: true,
-		})
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-// Insert takes `Item` struct, inserts it to Repo table,
-// iterates over child modules and inserts each module it to RepoToRepos table.
-func Insert(v structs.Item) {
-	repo := &Repo{
-		Name:            v.Name,
-		FullName:        v.FullName,
-		HTMLURL:         v.HTMLURL,
-		Description:     v.Description,
-		StargazersCount: v.StargazersCount,
-		ForksCount:      v.ForksCount,
-		AvatarURL:       v.Owner.AvatarURL,
-		Readme:          v.Readme,
-	}
-
-	_, err := DB.Model(repo).
-		OnConflict("(full_name) DO UPDATE").
-		Insert()
-
-	utils.HandleErrEXIT(err, "DB REPO INSERT")
-
-	for _, mod := range v.Modules {
-
-		module := &Repo{
-			Name:            mod.Name,
-			FullName:        mod.FullName,
-			HTMLURL:         mod.HTMLURL,
-			Description:     mod.Description,
-			StargazersCount: mod.StargazersCount,
-			ForksCount:      mod.ForksCount,
-			AvatarURL:       mod.Owner.AvatarURL,
-			Readme:          mod.Readme,
-		}
-
-		_, err := DB.Model(module).
-			OnConflict("(full_name) DO UPDATE").
-			Insert()
-
-		utils.HandleErrEXIT(err, "DB MODULE INSERT")
-
-		repoToModule := &RepoToRepos{RepoID: repo.ID, ModuleID: module.ID}
-
-		_, err = DB.Model(repoToModule).
-			Where("repo_id = ?repo_id").
-			Where("module_id = ?module_id").
-			SelectOrInsert()
-
-		utils.HandleErrEXIT(err, "DB REPO TO REPOS SELECT OR INSERT")
-
-	}
-
+// Insert inserts repo v, its modules and edges to them to Store.
+func Insert(v structs.Item) error {
+	return Store.Insert(v)
 }
 
 // SelectLimitOffset is a paginator. Selects limited items per page.
@@ -150,34 +128,25 @@ func SelectLimitOffset(page, limit string) ([]Repo, error) {
 
 	p, _ := utils.StrToInt(page)
 	l, _ := utils.StrToInt(limit)
-	offset := l * (p - 1)
 
-	var repos []Repo
-
-	err := DB.Model(&repos).
-		Column("id", "name", "full_name", "description", "stargazers_count", "forks_count", "avatar_url").
-		Order("stargazers_count DESC NULLS LAST").
-		Limit(l).
-		Offset(offset).
-		Select()
-
-	utils.HandleErrEXIT(err, "SELECT WITH LIMIT AND OFFSET")
+	if p < 1 {
+		p = 1
+	}
+	if l < 1 {
+		l = 10
+	}
 
-	return repos, nil
+	return Store.SelectLimitOffset(p, l)
 }
 
 // SelectALLByName selects all reposritories from table that have name = name.
 func SelectALLByName(name string) string {
 
-	result := []Repo{}
-
-	err := DB.Model(&result).
-		Column("id", "name", "full_name", "htmlurl", "stargazers_count", "forks_count", "description").
-		Where("name = ?", name).
-		Order("stargazers_count DESC NULLS LAST").
-		Select()
-
-	utils.HandleErrEXIT(err, "SELECT BY NAME")
+	result, err := Store.SelectByName(name)
+	if err != nil {
+		fmt.Println(err)
+		return ""
+	}
 
 	dbResponse := DBResponse{
 		Count: len(result),
@@ -192,19 +161,16 @@ func SelectALLByName(name string) string {
 // SelectByID selects all reposritories from table that have id = id.
 func SelectByID(id string) string {
 
-	var result Repo
-
-	err := DB.Model(&result).
-		Column("id", "name", "full_name", "htmlurl", "stargazers_count", "forks_count", "description", "avatar_url").
-		Where("id = ?", id).
-		Order("stargazers_count DESC NULLS LAST").
-		Select()
+	repoID, idErr := parseID(id)
+	if idErr != nil {
+		return ""
+	}
 
+	result, err := Store.SelectByID(repoID)
 	if err != nil {
 		return ""
 	}
 
-	// j, _ := json.MarshalIndent(result, "", "  ")
 	j, _ := json.Marshal(result)
 	return string(j)
 
@@ -213,98 +179,59 @@ func SelectByID(id string) string {
 // SelectByIDWithModules selects single module and its child modules.
 func SelectByIDWithModules(id, l string) string {
 
+	repoID, idErr := parseID(id)
 	level, levelErr := utils.StrToInt(l)
 
-	if levelErr != nil {
+	if idErr != nil || levelErr != nil {
 		return ""
 	}
 
-	var result Repo
-
-	err := DB.Model(&result).
-		Column("id", "name", "full_name", "htmlurl", "stargazers_count", "forks_count", "description", "avatar_url").
-		Where("id = ?", id).
-		Order("stargazers_count DESC NULLS LAST").
-		Select()
-
+	result, err := Store.SelectByID(repoID)
 	if err != nil {
 		return ""
 	}
 
-	result.Modules = queryModules(result.ID, level)
-	j, _ := json.Marshal(result)
-
-	/** takes too much RAM space **/
-	var buf bytes.Buffer
-	gzipErr := utils.Gzip(&buf, j)
-	if gzipErr != nil {
-		log.Println(gzipErr)
+	result.Modules, err = Store.QueryModules(result.ID, level)
+	if err != nil {
+		fmt.Println(err)
+		return ""
 	}
 
-	key := fmt.Sprintf("%s-%s", id, l)
-	redisClient.Set(key, buf.Bytes(), time.Minute*30).Result()
+	j, _ := json.Marshal(result)
 
 	return string(j)
 }
 
-func getQueryString(id int) string {
-	return fmt.Sprintf(`
-		SELECT "repo"."id", "repo"."name", "repo"."full_name", "repo"."stargazers_count", "repo"."forks_count", "repo"."avatar_url", "repo"."description"
-		FROM "repos" as "repo"
-		JOIN  "repo_to_repos" ON "repo"."id" = "repo_to_repos"."module_id"
-		WHERE ("repo_to_repos"."module_id" = "repo"."id") AND ("repo_to_repos"."repo_id"=%v)
-		ORDER BY "repo"."stargazers_count" DESC NULLS LAST;
-	`, id)
-}
-
-func appendPointers(modules []Repo) []*Repo {
-	p := []*Repo{}
-	for i := range modules {
-		p = append(p, &modules[i])
-	}
+// SelectDependents selects repos depending on module with id, directly or
+// transitively up to depth level l. Repos are ordered by stars.
+// Returns "" for invalid id or level and for module not found.
+func SelectDependents(id, l string) string {
 
-	return p
-}
+	moduleID, idErr := parseID(id)
+	level, levelErr := utils.StrToInt(l)
 
-func queryModules(id, level int) []Repo {
-	modules := []Repo{}
+	if idErr != nil || levelErr != nil || level < 1 {
+		return ""
+	}
 
-	query := getQueryString(id)
+	if _, err := Store.SelectByID(moduleID); err != nil {
+		return ""
+	}
 
-	_, err := DB.Model(&modules).Query(&modules, query)
+	result, err := Store.SelectDependents(moduleID, level)
 	if err != nil {
 		fmt.Println(err)
+		return ""
 	}
 
-	if level > 1 {
-		if level > 5 {
-			level = 5
-		}
-
-		modulesPts := appendPointers(modules)
-		for level > 1 {
-			pts := []*Repo{}
-
-			for len(modulesPts) > 0 {
-
-				child := modulesPts[0]
-				childModules := []Repo{}
-
-				q := getQueryString(child.ID)
-				DB.Model(&childModules).Query(&childModules, q)
-
-				child.Modules = childModules
-				pts = append(pts, appendPointers(childModules)...)
-
-				modulesPts = modulesPts[1:]
-			}
-
-			modulesPts = pts
-			level--
-		}
+	dbResponse := DBResponse{
+		Count: len(result),
+		Items: result,
 	}
 
-	return modules
+	j, _ := json.Marshal(dbResponse)
+
+	return string(j)
 }
 
 // SelectMultipleByID selects multuple repos
@@ -313,23 +240,21 @@ func SelectMultipleByID(ids string) string {
 	result := []Repo{}
 
 	for _, id := range idsStr {
-		idInt, err := utils.StrToInt(id)
+		idInt, err := parseID(id)
 		if err != nil {
 			continue
 		}
-		repo := new(Repo)
-		err = DB.Model(repo).
-			Column("id", "name", "full_name", "htmlurl", "stargazers_count", "forks_count", "description").
-			Where("id = ?", id).
-			Order("stargazers_count DESC NULLS LAST").
-			Select()
 
+		repo, err := Store.SelectByID(idInt)
 		if err != nil {
 			continue
 		}
 
-		repo.Modules = queryModules(idInt, 1)
-		result = append(result, *repo)
+		repo.Modules, err = Store.QueryModules(idInt, 1)
+		if err != nil {
+			continue
+		}
+		result = append(result, repo)
 	}
 
 	dbResponse := DBResponse{
@@ -343,45 +268,77 @@ func SelectMultipleByID(ids string) string {
 }
 
 // SelectReadme selects readme
-func SelectReadme(id string) string {
-	var readme string
+// Returns an error for invalid id or repo not found.
+func SelectReadme(id string) (string, error) {
+	repoID, err := parseID(id)
+	if err != nil {
+		return "", err
+	}
 
-	err := DB.Model((*Repo)(nil)).
-		Column("readme").
-		Where("id = ?", id).
-		Select(&readme)
+	readme, err := Store.SelectReadme(repoID)
 	if err != nil {
 		fmt.Println(err)
+		return "", fmt.Errorf("Repo %v not found", repoID)
 	}
 
-	return readme
+	return readme, nil
 }
 
-// Search searchs if name or full_name or description contains search term
-func Search(term string) []byte {
-	var repos []Repo
+// Search searchs repos by name, full_name, description and readme, best
+// matching and most starred first. Default limit is 50, max is 100.
+// If the first page is empty, term may be misspelled: repos with similar
+// names are returned with a suggestion instead.
+func Search(term, page, limit string) []byte {
 	fmt.Println(term, "SEARCH")
-	term = "%" + strings.ToLower(term) + "%"
-	titleTerm := strings.ToTitle(term) + "%"
-	err := DB.Model(&repos).
-		Column("id", "full_name", "avatar_url", "stargazers_count", "forks_count", "description").
-		Where("name like ?", term).
-		WhereOr("full_name like ?", term).
-		WhereOr("description like ?", term).
-		WhereOr("description like ?", titleTerm).
-		Order("stargazers_count DESC NULLS LAST").
-		Limit(50).
-		Select()
-
-	utils.HandleErrEXIT(err, "SEARCH")
+
+	p, l := 1, defaultSearchLimit
+	if page != "" {
+		p, _ = utils.StrToInt(page)
+	}
+	if limit != "" {
+		l, _ = utils.StrToInt(limit)
+	}
+	if p < 1 {
+		p = 1
+	}
+	if l < 1 {
+		l = defaultSearchLimit
+	}
+	if l > maxSearchLimit {
+		l = maxSearchLimit
+	}
+
+	repos, total, err := Store.Search(term, p, l)
+	utils.HandleErrLog(err, "SEARCH")
+
+	suggestion := ""
+	if err == nil && total == 0 && p == 1 {
+		repos, suggestion, err = Store.SearchSimilar(term, l)
+		utils.HandleErrLog(err, "SEARCH SIMILAR")
+		total = len(repos)
+	}
+
+	if repos == nil {
+		repos = []Repo{}
+	}
 
 	dbResponse := DBResponse{
-		Count: len(repos),
-		Items: repos,
+		Count:      len(repos),
+		Total:      total,
+		Suggestion: suggestion,
+		Items:      repos,
 	}
 
 	j, _ := json.MarshalIndent(dbResponse, "", "  ")
 
 	return j
-	// return string(j), nil
+}
+
+// parseID parses repo id received in request, it is a positive integer.
+func parseID(id string) (int, error) {
+	n, err := utils.StrToInt(id)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("Invalid id %q", id)
+	}
+	return n, nil
 }
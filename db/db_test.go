@@ -0,0 +1,29 @@
+package database
+
+import "testing"
+
+func TestParseID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want int
+		ok   bool
+	}{
+		{"1", 1, true},
+		{"42", 42, true},
+		{"2147483648", 2147483648, true},
+		{"0", 0, false},
+		{"-1", 0, false},
+		{"1e3", 0, false},
+		{"1 OR 1=1", 0, false},
+		{"1;DROP TABLE repos", 0, false},
+		{" 1", 0, false},
+		{"", 0, false},
+	}
+
+	for _, test := range tests {
+		got, err := parseID(test.id)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("parseID(%q) = %v, %v; want %v, ok %v", test.id, got, err, test.want, test.ok)
+		}
+	}
+}
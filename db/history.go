@@ -0,0 +1,251 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/a-sube/go-repos-api/utils"
+	"github.com/go-pg/pg"
+)
+
+// RepoSnapshot is a table and json response struct. It is stars and forks
+// count of repo at the time it was crawled.
+type RepoSnapshot struct {
+	ID              int       `json:"-"`
+	RepoID          int       `json:"repo_id" sql:",notnull"`
+	StargazersCount int       `json:"stargazers_count" sql:",notnull"`
+	ForksCount      int       `json:"forks_count" sql:",notnull"`
+	TakenAt         time.Time `json:"taken_at" sql:",notnull"`
+}
+
+// HistoryResponse is a json response struct
+type HistoryResponse struct {
+	RepoID    int
+	Count     int
+	Snapshots []RepoSnapshot
+}
+
+// trendingRow is a row selected by SelectTrending
+type trendingRow struct {
+	ID              int
+	Name            string
+	FullName        string
+	HTMLURL         string
+	StargazersCount int
+	ForksCount      int
+	Description     string
+	AvatarURL       string
+	StarGrowth      int
+}
+
+// insertSnapshot records current stars and forks count of repo
+func insertSnapshot(tx *pg.Tx, repo *Repo) error {
+	snapshot := &RepoSnapshot{
+		RepoID:          repo.ID,
+		StargazersCount: repo.StargazersCount,
+		ForksCount:      repo.ForksCount,
+		TakenAt:         time.Now(),
+	}
+
+	_, err := tx.Model(snapshot).Insert()
+	return err
+}
+
+const insertFirstSnapshotQuery = `
+	INSERT INTO "repo_snapshots" ("repo_id", "stargazers_count", "forks_count", "taken_at")
+	SELECT ?0, ?1, ?2, ?3
+	WHERE NOT EXISTS (SELECT 1 FROM "repo_snapshots" WHERE "repo_id" = ?0)
+`
+
+// insertFirstSnapshot records stars and forks count of repo unless it has
+// snapshots already
+func insertFirstSnapshot(tx *pg.Tx, repo *Repo) error {
+	_, err := tx.Exec(insertFirstSnapshotQuery, repo.ID, repo.StargazersCount, repo.ForksCount, time.Now())
+	return err
+}
+
+// SelectHistory selects snapshots of repo with id taken between from and to,
+// ordered by time. Both bounds are optional and accept RFC 3339 time or a
+// date `2006-01-02`.
+func SelectHistory(id, from, to string) string {
+
+	repoID, idErr := parseID(id)
+	fromTime, fromErr := parseTime(from, time.Time{})
+	toTime, toErr := parseTime(to, time.Now())
+
+	if idErr != nil || fromErr != nil || toErr != nil {
+		return ""
+	}
+
+	// date only upper bound includes the whole day
+	if len(to) == len(dateLayout) {
+		toTime = toTime.AddDate(0, 0, 1)
+	}
+
+	snapshots, err := Store.SelectHistory(repoID, fromTime, toTime)
+	if err != nil {
+		fmt.Println(err)
+		return ""
+	}
+
+	historyResponse := HistoryResponse{
+		RepoID:    repoID,
+		Count:     len(snapshots),
+		Snapshots: snapshots,
+	}
+
+	j, _ := json.Marshal(historyResponse)
+
+	return string(j)
+}
+
+// SelectTrending selects up to limit repos ranked by stars gained within
+// window, e.g. `24h` or `7d`. Default window is 7 days, default limit is 10.
+func SelectTrending(window, limit string) string {
+	if window == "" {
+		window = "7d"
+	}
+	if limit == "" {
+		limit = "10"
+	}
+
+	since, windowErr := parseWindow(window)
+	l, limitErr := utils.StrToInt(limit)
+
+	if windowErr != nil || limitErr != nil || l < 1 {
+		return ""
+	}
+	if l > 100 {
+		l = 100
+	}
+
+	result, err := Store.SelectTrending(time.Now().Add(-since), l)
+	if err != nil {
+		fmt.Println(err)
+		return ""
+	}
+
+	dbResponse := DBResponse{
+		Count: len(result),
+		Items: result,
+	}
+
+	j, _ := json.Marshal(dbResponse)
+
+	return string(j)
+}
+
+const selectHistoryQuery = `
+	SELECT "id", "repo_id", "stargazers_count", "forks_count", "taken_at"
+	FROM "repo_snapshots"
+	WHERE "repo_id" = ?0 AND "taken_at" >= ?1 AND "taken_at" < ?2
+	ORDER BY "taken_at" ASC
+`
+
+// SelectHistory selects snapshots of repo with id taken from from until to,
+// ordered by time.
+func (p *Postgres) SelectHistory(id int, from, to time.Time) ([]RepoSnapshot, error) {
+	snapshots := []RepoSnapshot{}
+
+	_, err := p.db.Query(&snapshots, selectHistoryQuery, id, from, to)
+	return snapshots, err
+}
+
+// trendingQuery selects up to ?1 repos ranked by stars gained since ?0
+const trendingQuery = `
+	WITH "latest" AS (
+		SELECT DISTINCT ON ("repo_id") "repo_id", "stargazers_count"
+		FROM "repo_snapshots"
+		WHERE "taken_at" > ?0
+		ORDER BY "repo_id", "taken_at" DESC
+	), "baseline" AS (
+		SELECT DISTINCT ON ("snapshot"."repo_id") "snapshot"."repo_id", "snapshot"."stargazers_count"
+		FROM "repo_snapshots" AS "snapshot"
+		JOIN "latest" ON "latest"."repo_id" = "snapshot"."repo_id"
+		WHERE "snapshot"."taken_at" <= ?0
+		ORDER BY "snapshot"."repo_id", "snapshot"."taken_at" DESC
+	), "first" AS (
+		SELECT DISTINCT ON ("repo_id") "repo_id", "stargazers_count"
+		FROM "repo_snapshots"
+		WHERE "taken_at" > ?0
+		ORDER BY "repo_id", "taken_at"
+	), "growth" AS (
+		SELECT "latest"."repo_id",
+			"latest"."stargazers_count" - coalesce("baseline"."stargazers_count", "first"."stargazers_count") AS "star_growth"
+		FROM "latest"
+		JOIN "first" ON "first"."repo_id" = "latest"."repo_id"
+		LEFT JOIN "baseline" ON "baseline"."repo_id" = "latest"."repo_id"
+	)
+	SELECT "repo"."id", "repo"."name", "repo"."full_name", "repo"."htmlurl", "repo"."stargazers_count", "repo"."forks_count",
+		"repo"."description", "repo"."avatar_url", "growth"."star_growth"
+	FROM "growth"
+	JOIN "repos" AS "repo" ON "repo"."id" = "growth"."repo_id"
+	WHERE "growth"."star_growth" > 0
+	ORDER BY "growth"."star_growth" DESC, "repo"."stargazers_count" DESC NULLS LAST
+	LIMIT ?1
+`
+
+// SelectTrending selects up to limit repos ranked by stars gained since.
+// Growth is a difference between the latest snapshot and the last one taken
+// at or before since, repos are crawled less often than the window may be
+// long. Repos first crawled after since are compared with their first
+// snapshot. Only repos crawled after since are ranked.
+func (p *Postgres) SelectTrending(since time.Time, limit int) ([]Repo, error) {
+	rows := []trendingRow{}
+
+	_, err := p.db.Query(&rows, trendingQuery, since, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Repo, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, Repo{
+			ID:              row.ID,
+			Name:            row.Name,
+			FullName:        row.FullName,
+			HTMLURL:         row.HTMLURL,
+			StargazersCount: row.StargazersCount,
+			ForksCount:      row.ForksCount,
+			Description:     row.Description,
+			AvatarURL:       row.AvatarURL,
+			StarGrowth:      row.StarGrowth,
+		})
+	}
+
+	return result, nil
+}
+
+const dateLayout = "2006-01-02"
+
+// parseTime parses RFC 3339 time or a date. Empty s is def.
+func parseTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(dateLayout, s)
+}
+
+// parseWindow parses duration, `d` suffix stands for days
+func parseWindow(window string) (time.Duration, error) {
+	if strings.HasSuffix(window, "d") {
+		days, err := utils.StrToInt(strings.TrimSuffix(window, "d"))
+		if err != nil || days < 1 {
+			return 0, fmt.Errorf("Invalid window %q", window)
+		}
+		return time.Hour * 24 * time.Duration(days), nil
+	}
+
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("Invalid window %q", window)
+	}
+	return d, nil
+}
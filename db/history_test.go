@@ -0,0 +1,95 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/a-sube/go-repos-api/structs"
+)
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		window string
+		want   time.Duration
+		ok     bool
+	}{
+		{"1d", time.Hour * 24, true},
+		{"7d", time.Hour * 24 * 7, true},
+		{"30d", time.Hour * 24 * 30, true},
+		{"24h", time.Hour * 24, true},
+		{"90m", time.Minute * 90, true},
+		{"1h30m", time.Minute * 90, true},
+		{"0d", 0, false},
+		{"-1d", 0, false},
+		{"d", 0, false},
+		{"1.5d", 0, false},
+		{"0h", 0, false},
+		{"-1h", 0, false},
+		{"7", 0, false},
+		{"week", 0, false},
+		{"", 0, false},
+	}
+
+	for _, test := range tests {
+		got, err := parseWindow(test.window)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("parseWindow(%q) = %v, %v; want %v, ok %v", test.window, got, err, test.want, test.ok)
+		}
+	}
+}
+
+func TestMemorySelectTrending(t *testing.T) {
+	m := NewMemory()
+	for _, name := range []string{"old", "new", "flat", "stale", "lost"} {
+		if err := m.Insert(structs.Item{Name: name, FullName: "trend/" + name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	since := now.Add(-time.Hour * 24 * 7)
+	days := func(n int) time.Time { return now.Add(-time.Hour * 24 * time.Duration(n)) }
+
+	snapshots := map[string][]RepoSnapshot{
+		// growth since the last snapshot before the window: 130 - 110
+		"old": {{StargazersCount: 100, TakenAt: days(30)}, {StargazersCount: 110, TakenAt: days(8)}, {StargazersCount: 130, TakenAt: days(1)}},
+		// first crawled within the window, compared with its first snapshot: 45 - 5
+		"new":  {{StargazersCount: 5, TakenAt: days(6)}, {StargazersCount: 45, TakenAt: days(1)}},
+		"flat": {{StargazersCount: 50, TakenAt: days(10)}, {StargazersCount: 50, TakenAt: days(2)}},
+		// not crawled within the window
+		"stale": {{StargazersCount: 1, TakenAt: days(20)}, {StargazersCount: 900, TakenAt: days(8)}},
+		"lost":  {{StargazersCount: 70, TakenAt: days(9)}, {StargazersCount: 60, TakenAt: days(3)}},
+	}
+	for name, list := range snapshots {
+		m.snapshots[m.ids["trend/"+name]] = list
+	}
+
+	tests := []struct {
+		since time.Time
+		limit int
+		want  []string
+	}{
+		{since, 10, []string{"new 40", "old 20"}},
+		{since, 1, []string{"new 40"}},
+		// longer window compares old and stale with their first snapshots
+		{days(31), 10, []string{"stale 899", "new 40", "old 30"}},
+		{now, 10, []string{}},
+	}
+
+	for _, test := range tests {
+		repos, err := m.SelectTrending(test.since, test.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := []string{}
+		for _, repo := range repos {
+			got = append(got, fmt.Sprintf("%s %d", repo.Name, repo.StarGrowth))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("since %v limit %d: trending %v, want %v", now.Sub(test.since), test.limit, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,629 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a-sube/go-repos-api/structs"
+)
+
+// Memory is a Storage keeping repos in memory. It is safe for concurrent use.
+type Memory struct {
+	mu     sync.RWMutex
+	nextID int
+	repos  map[int]*Repo
+	ids    map[string]int
+	// edges maps repo id to edges from it, keyed by module id
+	edges map[int]map[int]RepoToRepos
+	// snapshots maps repo id to its snapshots ordered by time
+	snapshots map[int][]RepoSnapshot
+	// removed holds edges dropped by complete crawls, like removed_edges table
+	removed []RemovedEdge
+}
+
+// NewMemory returns an empty in-memory Storage
+func NewMemory() *Memory {
+	return &Memory{
+		nextID:    1,
+		repos:     make(map[int]*Repo),
+		ids:       make(map[string]int),
+		edges:     make(map[int]map[int]RepoToRepos),
+		snapshots: make(map[int][]RepoSnapshot),
+	}
+}
+
+// Insert inserts or updates repo v, its modules and edges to them.
+func (m *Memory) Insert(v structs.Item) error {
+	if v.FullName == "" {
+		return fmt.Errorf("Insert: empty full name")
+	}
+
+	for _, mod := range v.Modules {
+		if mod.FullName == "" {
+			return fmt.Errorf("Insert %v: module with empty full name", v.FullName)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	repoID := m.upsert(v)
+
+	m.snapshots[repoID] = append(m.snapshots[repoID], RepoSnapshot{
+		RepoID:          repoID,
+		StargazersCount: v.StargazersCount,
+		ForksCount:      v.ForksCount,
+		TakenAt:         time.Now(),
+	})
+
+	edges, ok := m.edges[repoID]
+	if !ok {
+		edges = make(map[int]RepoToRepos)
+	}
+
+	seen := make(map[int]bool)
+	for _, mod := range v.Modules {
+		moduleID := m.upsertModule(*mod)
+		edges[moduleID] = RepoToRepos{
+			RepoID:   repoID,
+			ModuleID: moduleID,
+			Version:  mod.Version,
+			Indirect: mod.Indirect,
+			Replace:  mod.Replace,
+			SeenAt:   time.Now(),
+		}
+		seen[moduleID] = true
+	}
+
+	// complete modules replace all edges, stale ones are recorded and dropped
+	if v.ModulesComplete {
+		m.removeStaleEdges(edges, seen)
+	}
+
+	m.edges[repoID] = edges
+
+	return nil
+}
+
+// LoadMemory returns a Memory storage with items inserted from JSON file
+// holding an array of `Item` with their modules.
+func LoadMemory(file string) (*Memory, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []structs.Item
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("%v: %v", file, err)
+	}
+
+	m := NewMemory()
+	for _, item := range items {
+		if err := m.Insert(item); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// removeStaleEdges deletes edges to modules not in seen and records them
+// to removed edges in module id order.
+func (m *Memory) removeStaleEdges(edges map[int]RepoToRepos, seen map[int]bool) {
+	stale := []int{}
+	for moduleID := range edges {
+		if !seen[moduleID] {
+			stale = append(stale, moduleID)
+		}
+	}
+	sort.Ints(stale)
+
+	removedAt := time.Now()
+	for _, moduleID := range stale {
+		edge := edges[moduleID]
+		m.removed = append(m.removed, RemovedEdge{
+			ID:        len(m.removed) + 1,
+			RepoID:    edge.RepoID,
+			ModuleID:  edge.ModuleID,
+			Version:   edge.Version,
+			Indirect:  edge.Indirect,
+			Replace:   edge.Replace,
+			SeenAt:    edge.SeenAt,
+			RemovedAt: removedAt,
+		})
+		delete(edges, moduleID)
+	}
+}
+
+// upsertModule inserts or updates module repo of v like upsert, but keeps
+// counts of a known module, they change only when it is crawled itself.
+// New module gets its first snapshot.
+func (m *Memory) upsertModule(v structs.Item) int {
+	if id, ok := m.ids[v.FullName]; ok {
+		v.StargazersCount = m.repos[id].StargazersCount
+		v.ForksCount = m.repos[id].ForksCount
+	}
+
+	id := m.upsert(v)
+
+	if len(m.snapshots[id]) == 0 {
+		m.snapshots[id] = append(m.snapshots[id], RepoSnapshot{
+			RepoID:          id,
+			StargazersCount: v.StargazersCount,
+			ForksCount:      v.ForksCount,
+			TakenAt:         time.Now(),
+		})
+	}
+
+	return id
+}
+
+// upsert inserts or updates repo of v and returns its id
+func (m *Memory) upsert(v structs.Item) int {
+	id, ok := m.ids[v.FullName]
+	if !ok {
+		id = m.nextID
+		m.nextID++
+		m.ids[v.FullName] = id
+	}
+
+	m.repos[id] = &Repo{
+		ID:              id,
+		Name:            v.Name,
+		FullName:        v.FullName,
+		HTMLURL:         v.HTMLURL,
+		Description:     v.Description,
+		StargazersCount: v.StargazersCount,
+		ForksCount:      v.ForksCount,
+		AvatarURL:       v.Owner.AvatarURL,
+		Readme:          v.Readme,
+	}
+
+	return id
+}
+
+// SelectLimitOffset selects limit repos ordered by stars, skipping (page - 1) pages.
+func (m *Memory) SelectLimitOffset(page, limit int) ([]Repo, error) {
+	if page < 1 || limit < 0 {
+		return nil, fmt.Errorf("Invalid page %v or limit %v", page, limit)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	repos := m.sorted(func(*Repo) bool { return true })
+
+	start := limit * (page - 1)
+	if start > len(repos) {
+		start = len(repos)
+	}
+	end := start + limit
+	if end > len(repos) {
+		end = len(repos)
+	}
+
+	result := []Repo{}
+	for _, repo := range repos[start:end] {
+		result = append(result, Repo{
+			ID:              repo.ID,
+			Name:            repo.Name,
+			FullName:        repo.FullName,
+			Description:     repo.Description,
+			StargazersCount: repo.StargazersCount,
+			ForksCount:      repo.ForksCount,
+			AvatarURL:       repo.AvatarURL,
+		})
+	}
+
+	return result, nil
+}
+
+// SelectByName selects repos with name, ordered by stars.
+func (m *Memory) SelectByName(name string) ([]Repo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := []Repo{}
+	for _, repo := range m.sorted(func(repo *Repo) bool { return repo.Name == name }) {
+		result = append(result, Repo{
+			ID:              repo.ID,
+			Name:            repo.Name,
+			FullName:        repo.FullName,
+			HTMLURL:         repo.HTMLURL,
+			StargazersCount: repo.StargazersCount,
+			ForksCount:      repo.ForksCount,
+			Description:     repo.Description,
+		})
+	}
+
+	return result, nil
+}
+
+// SelectByID selects repo with id, without readme and modules.
+func (m *Memory) SelectByID(id int) (Repo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	repo, ok := m.repos[id]
+	if !ok {
+		return Repo{}, fmt.Errorf("Repo %v not found", id)
+	}
+
+	return Repo{
+		ID:              repo.ID,
+		Name:            repo.Name,
+		FullName:        repo.FullName,
+		HTMLURL:         repo.HTMLURL,
+		StargazersCount: repo.StargazersCount,
+		ForksCount:      repo.ForksCount,
+		Description:     repo.Description,
+		AvatarURL:       repo.AvatarURL,
+	}, nil
+}
+
+// QueryModules selects modules of repo with id up to level, 5 at most.
+// Modules are ordered by stars. Same as modulesTreeQuery does, a module
+// already on the path from the repo is not followed, so cycles end.
+func (m *Memory) QueryModules(id, level int) ([]Repo, error) {
+	if level > 5 {
+		level = 5
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.modules(id, level, []int{id}), nil
+}
+
+// modules returns modules of repo with id, path is ids from the queried
+// repo down to id.
+func (m *Memory) modules(id, level int, path []int) []Repo {
+	edges := m.edges[id]
+
+	modules := m.sorted(func(repo *Repo) bool {
+		_, ok := edges[repo.ID]
+		return ok && !containsID(path, repo.ID)
+	})
+
+	result := make([]Repo, 0, len(modules))
+	for _, repo := range modules {
+		edge := edges[repo.ID]
+
+		module := Repo{
+			ID:              repo.ID,
+			Name:            repo.Name,
+			FullName:        repo.FullName,
+			StargazersCount: repo.StargazersCount,
+			ForksCount:      repo.ForksCount,
+			AvatarURL:       repo.AvatarURL,
+			Description:     repo.Description,
+			Edge:            &edge,
+		}
+
+		if level > 1 {
+			module.Modules = m.modules(repo.ID, level-1, append(path[:len(path):len(path)], repo.ID))
+		}
+
+		result = append(result, module)
+	}
+
+	return result
+}
+
+// Search selects limit repos matching all words of term as prefixes of words
+// of their name, full name, description or readme, skipping (page - 1) pages.
+// Repos are ranked by where words match, name first, weighted by stars.
+func (m *Memory) Search(term string, page, limit int) ([]Repo, int, error) {
+	words := searchWords(term)
+	if len(words) == 0 || page < 1 || limit < 0 {
+		return []Repo{}, 0, nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ranks := make(map[int]float64)
+	repos := m.sorted(func(repo *Repo) bool {
+		rank := searchRank(repo, words)
+		ranks[repo.ID] = rank
+		return rank > 0
+	})
+
+	sort.SliceStable(repos, func(i, j int) bool {
+		return ranks[repos[i].ID] > ranks[repos[j].ID]
+	})
+
+	total := len(repos)
+
+	start := limit * (page - 1)
+	if start > len(repos) {
+		start = len(repos)
+	}
+	end := start + limit
+	if end > len(repos) {
+		end = len(repos)
+	}
+
+	result := []Repo{}
+	for _, repo := range repos[start:end] {
+		text, ok := markWords(repo.Description, words)
+		if !ok {
+			text, ok = markWords(readmeText(repo.Readme), words)
+		}
+		if !ok {
+			text = snippet(repo.Description)
+		}
+
+		result = append(result, Repo{
+			ID:              repo.ID,
+			FullName:        repo.FullName,
+			AvatarURL:       repo.AvatarURL,
+			StargazersCount: repo.StargazersCount,
+			ForksCount:      repo.ForksCount,
+			Description:     repo.Description,
+			Snippet:         text,
+		})
+	}
+
+	return result, total, nil
+}
+
+// SearchSimilar selects up to limit repos which name or full name is similar
+// to term by trigrams, most similar and most starred first. Suggestion is
+// name or full name of the first repo, whichever is more similar.
+func (m *Memory) SearchSimilar(term string, limit int) ([]Repo, string, error) {
+	term = strings.Join(searchWords(term), " ")
+	if term == "" {
+		return []Repo{}, "", nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	type similar struct {
+		repo       *Repo
+		score      float64
+		suggestion string
+	}
+
+	found := []similar{}
+	for _, repo := range m.sorted(func(*Repo) bool { return true }) {
+		name, fullName := similarity(repo.Name, term), similarity(repo.FullName, term)
+
+		s := similar{repo: repo, score: name, suggestion: repo.Name}
+		if fullName > name {
+			s.score, s.suggestion = fullName, repo.FullName
+		}
+
+		if s.score >= similarityThreshold {
+			found = append(found, s)
+		}
+	}
+
+	// stable, repos with the same score stay ordered by stars
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].score > found[j].score
+	})
+
+	if limit >= 0 && len(found) > limit {
+		found = found[:limit]
+	}
+
+	suggestion := ""
+	result := []Repo{}
+	for i, s := range found {
+		if i == 0 {
+			suggestion = s.suggestion
+		}
+
+		result = append(result, Repo{
+			ID:              s.repo.ID,
+			FullName:        s.repo.FullName,
+			AvatarURL:       s.repo.AvatarURL,
+			StargazersCount: s.repo.StargazersCount,
+			ForksCount:      s.repo.ForksCount,
+			Description:     s.repo.Description,
+		})
+	}
+
+	return result, suggestion, nil
+}
+
+// searchRank returns rank of repo matching words, 0 if any word doesn't match.
+// Weights of name, description and readme are ts_rank defaults.
+func searchRank(repo *Repo, words []string) float64 {
+	fields := []struct {
+		words  []string
+		weight float64
+	}{
+		{searchWords(repo.Name + " " + repo.FullName), 1},
+		{searchWords(repo.Description), 0.4},
+		{searchWords(readmeText(repo.Readme)), 0.2},
+	}
+
+	rank := 0.0
+	for _, word := range words {
+		weight := 0.0
+		for _, field := range fields {
+			if field.weight > weight && hasPrefix(field.words, []string{word}) {
+				weight = field.weight
+			}
+		}
+
+		if weight == 0 {
+			return 0
+		}
+		rank += weight
+	}
+
+	return rank * math.Log(2+float64(repo.StargazersCount))
+}
+
+// SelectReadme selects readme of repo with id.
+func (m *Memory) SelectReadme(id int) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	repo, ok := m.repos[id]
+	if !ok {
+		return "", fmt.Errorf("Repo %v not found", id)
+	}
+
+	return repo.Readme, nil
+}
+
+// SelectDependents selects repos depending on module with id, directly or
+// transitively up to level, with Depth set. Repos are ordered by stars.
+func (m *Memory) SelectDependents(id, level int) ([]Repo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	depths := map[int]int{id: 0}
+	frontier := []int{id}
+
+	for depth := 1; depth <= level && len(frontier) > 0; depth++ {
+		next := []int{}
+		for repoID, edges := range m.edges {
+			if _, seen := depths[repoID]; seen {
+				continue
+			}
+			for _, moduleID := range frontier {
+				if _, ok := edges[moduleID]; ok {
+					depths[repoID] = depth
+					next = append(next, repoID)
+					break
+				}
+			}
+		}
+		frontier = next
+	}
+
+	delete(depths, id)
+
+	result := []Repo{}
+	for _, repo := range m.sorted(func(repo *Repo) bool {
+		_, ok := depths[repo.ID]
+		return ok
+	}) {
+		result = append(result, Repo{
+			ID:              repo.ID,
+			Name:            repo.Name,
+			FullName:        repo.FullName,
+			HTMLURL:         repo.HTMLURL,
+			StargazersCount: repo.StargazersCount,
+			ForksCount:      repo.ForksCount,
+			Description:     repo.Description,
+			AvatarURL:       repo.AvatarURL,
+			Depth:           depths[repo.ID],
+		})
+	}
+
+	return result, nil
+}
+
+// SelectHistory selects snapshots of repo with id taken from from until to,
+// ordered by time.
+func (m *Memory) SelectHistory(id int, from, to time.Time) ([]RepoSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	snapshots := []RepoSnapshot{}
+	for _, snapshot := range m.snapshots[id] {
+		if !snapshot.TakenAt.Before(from) && snapshot.TakenAt.Before(to) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+
+	return snapshots, nil
+}
+
+// SelectTrending selects up to limit repos ranked by stars gained since,
+// the same way Postgres does.
+func (m *Memory) SelectTrending(since time.Time, limit int) ([]Repo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	growth := make(map[int]int)
+	for repoID, snapshots := range m.snapshots {
+		var baseline, first *RepoSnapshot
+		for i := range snapshots {
+			if !snapshots[i].TakenAt.After(since) {
+				baseline = &snapshots[i]
+			} else if first == nil {
+				first = &snapshots[i]
+			}
+		}
+
+		if first == nil {
+			continue
+		}
+		if baseline == nil {
+			baseline = first
+		}
+
+		if g := snapshots[len(snapshots)-1].StargazersCount - baseline.StargazersCount; g > 0 {
+			growth[repoID] = g
+		}
+	}
+
+	repos := m.sorted(func(repo *Repo) bool { return growth[repo.ID] > 0 })
+	sort.SliceStable(repos, func(i, j int) bool {
+		return growth[repos[i].ID] > growth[repos[j].ID]
+	})
+
+	if len(repos) > limit {
+		repos = repos[:limit]
+	}
+
+	result := []Repo{}
+	for _, repo := range repos {
+		result = append(result, Repo{
+			ID:              repo.ID,
+			Name:            repo.Name,
+			FullName:        repo.FullName,
+			HTMLURL:         repo.HTMLURL,
+			StargazersCount: repo.StargazersCount,
+			ForksCount:      repo.ForksCount,
+			Description:     repo.Description,
+			AvatarURL:       repo.AvatarURL,
+			StarGrowth:      growth[repo.ID],
+		})
+	}
+
+	return result, nil
+}
+
+// sorted returns repos matching filter ordered by stars, then by id
+func (m *Memory) sorted(filter func(*Repo) bool) []*Repo {
+	repos := []*Repo{}
+	for _, repo := range m.repos {
+		if filter(repo) {
+			repos = append(repos, repo)
+		}
+	}
+
+	sort.Slice(repos, func(i, j int) bool {
+		if repos[i].StargazersCount == repos[j].StargazersCount {
+			return repos[i].ID < repos[j].ID
+		}
+		return repos[i].StargazersCount > repos[j].StargazersCount
+	})
+
+	return repos
+}
+
+func containsID(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
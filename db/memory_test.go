@@ -0,0 +1,156 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/a-sube/go-repos-api/structs"
+)
+
+// tree returns modules as `name(children...)`, e.g. `b(c)`
+func tree(modules []Repo) string {
+	s := ""
+	for i, module := range modules {
+		if i > 0 {
+			s += " "
+		}
+		s += module.Name
+		if len(module.Modules) > 0 {
+			s += fmt.Sprintf("(%s)", tree(module.Modules))
+		}
+	}
+	return s
+}
+
+func TestMemoryModulesCycle(t *testing.T) {
+	// a -> b, b -> b and c, c -> a and b
+	m, err := LoadMemory("testdata/cycle.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		level int
+		want  string
+	}{
+		{"cycle/a", 1, "b"},
+		{"cycle/a", 2, "b(c)"},
+		{"cycle/a", 5, "b(c)"},
+		{"cycle/b", 5, "c(a)"},
+		{"cycle/c", 5, "a(b) b"},
+	}
+
+	for _, test := range tests {
+		modules, err := m.QueryModules(m.ids[test.name], test.level)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := tree(modules); got != test.want {
+			t.Errorf("%s depth %d: modules %q, want %q", test.name, test.level, got, test.want)
+		}
+	}
+}
+
+// edgeVersions returns versions of edges from repo keyed by module name
+func edgeVersions(m *Memory, repo string) map[string]string {
+	versions := make(map[string]string)
+	for moduleID, edge := range m.edges[m.ids[repo]] {
+		versions[m.repos[moduleID].FullName] = edge.Version
+	}
+	return versions
+}
+
+func moduleItem(name, version string) *structs.Item {
+	return &structs.Item{Name: name, FullName: "mod/" + name, Version: version}
+}
+
+func TestMemoryStaleEdges(t *testing.T) {
+	m := NewMemory()
+
+	insert := func(complete bool, modules ...*structs.Item) {
+		item := structs.Item{Name: "app", FullName: "repo/app", Modules: modules, ModulesComplete: complete}
+		if err := m.Insert(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	insert(true, moduleItem("a", "v1.0.0"), moduleItem("b", "v1.1.0"), moduleItem("c", "v0.1.0"))
+
+	// incomplete crawl keeps edges it didn't see
+	insert(false, moduleItem("a", "v1.0.1"))
+	want := map[string]string{"mod/a": "v1.0.1", "mod/b": "v1.1.0", "mod/c": "v0.1.0"}
+	if got := edgeVersions(m, "repo/app"); !reflect.DeepEqual(got, want) {
+		t.Errorf("edges after incomplete crawl %v, want %v", got, want)
+	}
+	if len(m.removed) != 0 {
+		t.Errorf("removed edges after incomplete crawl %+v, want none", m.removed)
+	}
+
+	// complete crawl drops and records stale edges
+	insert(true, moduleItem("a", "v1.0.2"))
+	want = map[string]string{"mod/a": "v1.0.2"}
+	if got := edgeVersions(m, "repo/app"); !reflect.DeepEqual(got, want) {
+		t.Errorf("edges after complete crawl %v, want %v", got, want)
+	}
+
+	removed := []string{}
+	for _, edge := range m.removed {
+		if edge.RepoID != m.ids["repo/app"] || edge.RemovedAt.IsZero() || edge.SeenAt.IsZero() {
+			t.Errorf("removed edge %+v", edge)
+		}
+		removed = append(removed, m.repos[edge.ModuleID].FullName+" "+edge.Version)
+	}
+	if want := []string{"mod/b v1.1.0", "mod/c v0.1.0"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed edges %v, want %v", removed, want)
+	}
+
+	// complete crawl without modules drops the rest
+	insert(true)
+	if got := edgeVersions(m, "repo/app"); len(got) != 0 {
+		t.Errorf("edges after empty complete crawl %v, want none", got)
+	}
+	if len(m.removed) != 3 {
+		t.Errorf("%d removed edges, want 3", len(m.removed))
+	}
+}
+
+func TestMemoryModuleCounts(t *testing.T) {
+	m := NewMemory()
+
+	insert := func(item structs.Item) {
+		if err := m.Insert(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counts := func() (int, []int) {
+		id := m.ids["mod/a"]
+		stars := []int{}
+		for _, snapshot := range m.snapshots[id] {
+			stars = append(stars, snapshot.StargazersCount)
+		}
+		return m.repos[id].StargazersCount, stars
+	}
+
+	a := moduleItem("a", "v1.0.0")
+	a.StargazersCount = 50
+	app := structs.Item{Name: "app", FullName: "repo/app", Modules: []*structs.Item{a}}
+
+	// new module gets its first snapshot
+	insert(app)
+	if stars, snapshots := counts(); stars != 50 || !reflect.DeepEqual(snapshots, []int{50}) {
+		t.Errorf("new module: %d stars, snapshots %v; want 50, [50]", stars, snapshots)
+	}
+
+	// module crawled itself records a snapshot
+	insert(structs.Item{Name: "a", FullName: "mod/a", StargazersCount: 60})
+
+	// known module keeps its counts when crawled as a requirement
+	a.StargazersCount = 40
+	insert(app)
+	if stars, snapshots := counts(); stars != 60 || !reflect.DeepEqual(snapshots, []int{50, 60}) {
+		t.Errorf("known module: %d stars, snapshots %v; want 60, [50 60]", stars, snapshots)
+	}
+}
@@ -0,0 +1,404 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-pg/pg"
+)
+
+// Migration is a numbered schema change. Up statements apply it, Down
+// statements revert it. Both run in a single transaction.
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// SchemaMigration is a table struct. It is a migration applied to database.
+type SchemaMigration struct {
+	Version   int       `sql:",pk"`
+	Name      string    `sql:",notnull"`
+	AppliedAt time.Time `sql:",notnull"`
+}
+
+// MigrationState is a migration and time it was applied, zero if pending.
+// Unknown is set for a migration applied to database but missing in
+// Migrations, database was migrated by a newer binary then.
+type MigrationState struct {
+	Migration
+	AppliedAt time.Time
+	Unknown   bool
+}
+
+// Applied reports whether migration is applied
+func (s MigrationState) Applied() bool {
+	return !s.AppliedAt.IsZero()
+}
+
+// Migrations are schema changes ordered by version. Released migrations are
+// never edited, a change of schema is a new migration appended to the list.
+// The first ones are written with IF NOT EXISTS, so databases created by
+// CreateTable before migrations existed are migrated as well.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_repos",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS "repos" (
+				"id" bigserial,
+				"name" text,
+				"full_name" text,
+				"htmlurl" text,
+				"description" text,
+				"stargazers_count" bigint,
+				"forks_count" bigint,
+				"avatar_url" text,
+				"readme" text,
+				PRIMARY KEY ("id"),
+				UNIQUE ("full_name")
+			)`,
+			`CREATE TABLE IF NOT EXISTS "repo_to_repos" (
+				"repo_id" bigint,
+				"module_id" bigint
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE "repo_to_repos"`,
+			`DROP TABLE "repos"`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_edge_metadata",
+		Up: []string{
+			`ALTER TABLE "repo_to_repos" ADD COLUMN IF NOT EXISTS "version" text`,
+			`ALTER TABLE "repo_to_repos" ADD COLUMN IF NOT EXISTS "indirect" boolean NOT NULL DEFAULT false`,
+			`ALTER TABLE "repo_to_repos" ADD COLUMN IF NOT EXISTS "replace" text`,
+			`ALTER TABLE "repo_to_repos" ADD COLUMN IF NOT EXISTS "seen_at" timestamptz`,
+		},
+		Down: []string{
+			`ALTER TABLE "repo_to_repos" DROP COLUMN "seen_at"`,
+			`ALTER TABLE "repo_to_repos" DROP COLUMN "replace"`,
+			`ALTER TABLE "repo_to_repos" DROP COLUMN "indirect"`,
+			`ALTER TABLE "repo_to_repos" DROP COLUMN "version"`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create_repo_snapshots",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS "repo_snapshots" (
+				"id" bigserial,
+				"repo_id" bigint NOT NULL,
+				"stargazers_count" bigint NOT NULL,
+				"forks_count" bigint NOT NULL,
+				"taken_at" timestamptz NOT NULL,
+				PRIMARY KEY ("id")
+			)`,
+			`CREATE INDEX IF NOT EXISTS "repo_snapshots_repo_id_taken_at_idx" ON "repo_snapshots" ("repo_id", "taken_at")`,
+			`CREATE INDEX IF NOT EXISTS "repo_snapshots_taken_at_idx" ON "repo_snapshots" ("taken_at")`,
+		},
+		Down: []string{
+			`DROP TABLE "repo_snapshots"`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create_removed_edges",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS "removed_edges" (
+				"id" bigserial,
+				"repo_id" bigint NOT NULL,
+				"module_id" bigint NOT NULL,
+				"version" text,
+				"seen_at" timestamptz,
+				"removed_at" timestamptz NOT NULL,
+				PRIMARY KEY ("id")
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE "removed_edges"`,
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add_repos_search",
+		Up: []string{
+			`ALTER TABLE "repos" ADD COLUMN "search" tsvector GENERATED ALWAYS AS (
+				setweight(to_tsvector('simple', coalesce("name", '') || ' ' || replace(coalesce("full_name", ''), '/', ' ')), 'A') ||
+				setweight(to_tsvector('english', coalesce("description", '')), 'B') ||
+				setweight(to_tsvector('english', coalesce("readme", '')), 'C')
+			) STORED`,
+			`CREATE INDEX "repos_search_idx" ON "repos" USING GIN ("search")`,
+		},
+		Down: []string{
+			`DROP INDEX "repos_search_idx"`,
+			`ALTER TABLE "repos" DROP COLUMN "search"`,
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add_repos_trigram_indexes",
+		Up: []string{
+			`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+			`CREATE INDEX "repos_name_trgm_idx" ON "repos" USING GIN ("name" gin_trgm_ops)`,
+			`CREATE INDEX "repos_full_name_trgm_idx" ON "repos" USING GIN ("full_name" gin_trgm_ops)`,
+		},
+		// extension is kept, other databases objects may use it
+		Down: []string{
+			`DROP INDEX "repos_full_name_trgm_idx"`,
+			`DROP INDEX "repos_name_trgm_idx"`,
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_removed_edges_indirect_replace",
+		Up: []string{
+			`ALTER TABLE "removed_edges" ADD COLUMN "indirect" boolean NOT NULL DEFAULT false`,
+			`ALTER TABLE "removed_edges" ADD COLUMN "replace" text`,
+		},
+		Down: []string{
+			`ALTER TABLE "removed_edges" DROP COLUMN "replace"`,
+			`ALTER TABLE "removed_edges" DROP COLUMN "indirect"`,
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add_repo_to_repos_unique_and_indexes",
+		Up: []string{
+			// duplicated edges are left by concurrent inserts before the
+			// constraint existed, one of them is kept
+			`DELETE FROM "repo_to_repos" AS "a" USING "repo_to_repos" AS "b"
+				WHERE "a"."repo_id" = "b"."repo_id" AND "a"."module_id" = "b"."module_id" AND "a".ctid < "b".ctid`,
+			`ALTER TABLE "repo_to_repos" ADD CONSTRAINT "repo_to_repos_repo_id_module_id_key" UNIQUE ("repo_id", "module_id")`,
+			`CREATE INDEX "repo_to_repos_repo_id_idx" ON "repo_to_repos" ("repo_id")`,
+			`CREATE INDEX "repo_to_repos_module_id_idx" ON "repo_to_repos" ("module_id")`,
+		},
+		Down: []string{
+			`DROP INDEX "repo_to_repos_module_id_idx"`,
+			`DROP INDEX "repo_to_repos_repo_id_idx"`,
+			`ALTER TABLE "repo_to_repos" DROP CONSTRAINT "repo_to_repos_repo_id_module_id_key"`,
+		},
+	},
+	{
+		Version: 9,
+		Name:    "search_readme_text",
+		// vector of full readme HTML may exceed tsvector size limit of 1MB,
+		// readme is stripped of tags and truncated to readmeSearchLength
+		Up: []string{
+			`DROP INDEX "repos_search_idx"`,
+			`ALTER TABLE "repos" DROP COLUMN "search"`,
+			`ALTER TABLE "repos" ADD COLUMN "search" tsvector GENERATED ALWAYS AS (
+				setweight(to_tsvector('simple', coalesce("name", '') || ' ' || replace(coalesce("full_name", ''), '/', ' ')), 'A') ||
+				setweight(to_tsvector('english', coalesce("description", '')), 'B') ||
+				setweight(to_tsvector('english', left(regexp_replace(coalesce("readme", ''), '<[^>]*>', ' ', 'g'), 100000)), 'C')
+			) STORED`,
+			`CREATE INDEX "repos_search_idx" ON "repos" USING GIN ("search")`,
+		},
+		Down: []string{
+			`DROP INDEX "repos_search_idx"`,
+			`ALTER TABLE "repos" DROP COLUMN "search"`,
+			`ALTER TABLE "repos" ADD COLUMN "search" tsvector GENERATED ALWAYS AS (
+				setweight(to_tsvector('simple', coalesce("name", '') || ' ' || replace(coalesce("full_name", ''), '/', ' ')), 'A') ||
+				setweight(to_tsvector('english', coalesce("description", '')), 'B') ||
+				setweight(to_tsvector('english', coalesce("readme", '')), 'C')
+			) STORED`,
+			`CREATE INDEX "repos_search_idx" ON "repos" USING GIN ("search")`,
+		},
+	},
+}
+
+// migrationsLock is a key of advisory lock held while migrating,
+// so concurrent migrations wait for each other.
+const migrationsLock = 7305617
+
+const createMigrationsTable = `
+	CREATE TABLE IF NOT EXISTS "schema_migrations" (
+		"version" bigint,
+		"name" text NOT NULL,
+		"applied_at" timestamptz NOT NULL,
+		PRIMARY KEY ("version")
+	)
+`
+
+// MigrateUp applies all pending migrations in order and returns count of
+// applied ones. Each migration is applied in its own transaction.
+func MigrateUp() (int, error) {
+	if _, err := DB.Exec(createMigrationsTable); err != nil {
+		return 0, fmt.Errorf("schema_migrations: %v", err)
+	}
+
+	count := 0
+	for _, m := range Migrations {
+		applied := false
+
+		err := DB.RunInTransaction(func(tx *pg.Tx) error {
+			if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(?)`, migrationsLock); err != nil {
+				return err
+			}
+
+			// checked under the lock, another process may have applied it
+			n, err := tx.Model((*SchemaMigration)(nil)).Where("version = ?", m.Version).Count()
+			if err != nil || n > 0 {
+				return err
+			}
+
+			for _, statement := range m.Up {
+				if _, err := tx.Exec(statement); err != nil {
+					return err
+				}
+			}
+
+			applied = true
+			_, err = tx.Model(&SchemaMigration{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now(),
+			}).Insert()
+			return err
+		})
+
+		if err != nil {
+			return count, fmt.Errorf("migration %d %s up: %v", m.Version, m.Name, err)
+		}
+		if applied {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// MigrateDown reverts the last applied migration and returns it.
+// Returns false if no migration is applied.
+func MigrateDown() (Migration, bool, error) {
+	if _, err := DB.Exec(createMigrationsTable); err != nil {
+		return Migration{}, false, fmt.Errorf("schema_migrations: %v", err)
+	}
+
+	var (
+		m  Migration
+		ok bool
+	)
+
+	err := DB.RunInTransaction(func(tx *pg.Tx) error {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(?)`, migrationsLock); err != nil {
+			return err
+		}
+
+		var last SchemaMigration
+		err := tx.Model(&last).Order("version DESC").Limit(1).Select()
+		if err == pg.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		m, ok = findMigration(last.Version)
+		if !ok {
+			return fmt.Errorf("migration %d %s is unknown", last.Version, last.Name)
+		}
+
+		for _, statement := range m.Down {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Model(&last).WherePK().Delete()
+		return err
+	})
+
+	if err != nil {
+		return Migration{}, false, fmt.Errorf("migration down: %v", err)
+	}
+	return m, ok, nil
+}
+
+// MigrationStatus returns all known migrations with time they were applied,
+// followed by applied migrations unknown to this binary. It doesn't change
+// database, all migrations are pending if schema_migrations doesn't exist.
+func MigrationStatus() ([]MigrationState, error) {
+	var exists bool
+	if _, err := DB.QueryOne(pg.Scan(&exists), `SELECT to_regclass('schema_migrations') IS NOT NULL`); err != nil {
+		return nil, fmt.Errorf("schema_migrations: %v", err)
+	}
+
+	applied := []SchemaMigration{}
+	if exists {
+		if err := DB.Model(&applied).Order("version").Select(); err != nil {
+			return nil, err
+		}
+	}
+
+	return migrationStates(applied), nil
+}
+
+// migrationStates merges Migrations with applied ones
+func migrationStates(applied []SchemaMigration) []MigrationState {
+	appliedAt := make(map[int]time.Time, len(applied))
+	for _, a := range applied {
+		appliedAt[a.Version] = a.AppliedAt
+	}
+
+	states := make([]MigrationState, 0, len(Migrations))
+	for _, m := range Migrations {
+		states = append(states, MigrationState{Migration: m, AppliedAt: appliedAt[m.Version]})
+	}
+
+	for _, a := range applied {
+		if _, ok := findMigration(a.Version); !ok {
+			states = append(states, MigrationState{
+				Migration: Migration{Version: a.Version, Name: a.Name},
+				AppliedAt: a.AppliedAt,
+				Unknown:   true,
+			})
+		}
+	}
+
+	return states
+}
+
+// CheckMigrations returns an error if any migration is not applied yet or
+// database has migrations this binary doesn't know. It doesn't change database.
+func CheckMigrations() error {
+	states, err := MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	return checkStates(states)
+}
+
+func checkStates(states []MigrationState) error {
+	pending := 0
+	unknown := []int{}
+	for _, state := range states {
+		switch {
+		case state.Unknown:
+			unknown = append(unknown, state.Version)
+		case !state.Applied():
+			pending++
+		}
+	}
+
+	if len(unknown) > 0 {
+		return fmt.Errorf("migrations %v are applied but unknown, database is migrated by a newer version", unknown)
+	}
+	if pending > 0 {
+		return fmt.Errorf("%d pending migrations, run `migrate up`", pending)
+	}
+	return nil
+}
+
+func findMigration(version int) (Migration, bool) {
+	for _, m := range Migrations {
+		if m.Version == version {
+			return m, true
+		}
+	}
+	return Migration{}, false
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckMigrationStates(t *testing.T) {
+	now := time.Now()
+
+	all := []SchemaMigration{}
+	for _, m := range Migrations {
+		all = append(all, SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: now})
+	}
+	newer := append(all[:len(all):len(all)], SchemaMigration{Version: 1000, Name: "from_the_future", AppliedAt: now})
+
+	tests := []struct {
+		name    string
+		applied []SchemaMigration
+		ok      bool
+	}{
+		{"all applied", all, true},
+		{"none applied", nil, false},
+		{"last pending", all[:len(all)-1], false},
+		{"unknown applied", newer, false},
+	}
+
+	for _, test := range tests {
+		err := checkStates(migrationStates(test.applied))
+		if (err == nil) != test.ok {
+			t.Errorf("%s: error %v, want ok %v", test.name, err, test.ok)
+		}
+	}
+
+	states := migrationStates(newer)
+	last := states[len(states)-1]
+	if !last.Unknown || last.Version != 1000 || !last.Applied() {
+		t.Errorf("last state %+v, want applied unknown migration 1000", last)
+	}
+}
+
+func TestMigrationVersionsAscending(t *testing.T) {
+	for i := 1; i < len(Migrations); i++ {
+		if Migrations[i].Version <= Migrations[i-1].Version {
+			t.Errorf("migration %d follows %d", Migrations[i].Version, Migrations[i-1].Version)
+		}
+	}
+}
@@ -0,0 +1,552 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/a-sube/go-repos-api/structs"
+	"github.com/go-pg/pg"
+)
+
+// Postgres is a Storage keeping repos in PostgreSQL. Request parameters
+// of queries are bound as `?N` parameters, go-pg quotes them and they are
+// never formatted into SQL with fmt.
+type Postgres struct {
+	db *pg.DB
+}
+
+// NewPostgres returns a Storage using db
+func NewPostgres(db *pg.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Insert takes `Item` struct, inserts it to Repo table, records its stars and forks
+// snapshot, iterates over child modules and inserts each module it to RepoToRepos table.
+// Everything is inserted in a single transaction, nothing is inserted on error.
+func (p *Postgres) Insert(v structs.Item) error {
+	repo := &Repo{
+		Name:            v.Name,
+		FullName:        v.FullName,
+		HTMLURL:         v.HTMLURL,
+		Description:     v.Description,
+		StargazersCount: v.StargazersCount,
+		ForksCount:      v.ForksCount,
+		AvatarURL:       v.Owner.AvatarURL,
+		Readme:          v.Readme,
+	}
+
+	err := p.db.RunInTransaction(func(tx *pg.Tx) error {
+		_, err := tx.Model(repo).
+			OnConflict("(full_name) DO UPDATE").
+			Insert()
+		if err != nil {
+			return fmt.Errorf("repo: %v", err)
+		}
+
+		if err := insertSnapshot(tx, repo); err != nil {
+			return fmt.Errorf("snapshot: %v", err)
+		}
+
+		return insertEdges(tx, repo.ID, v)
+	})
+
+	if err != nil {
+		return fmt.Errorf("Insert %v: %v", v.FullName, err)
+	}
+	return nil
+}
+
+// insertEdges inserts modules of v and upserts edges from repo with repoID to them.
+// If v.ModulesComplete is set, edges to modules v doesn't require anymore are removed.
+func insertEdges(tx *pg.Tx, repoID int, v structs.Item) error {
+	moduleIDs := []int{}
+
+	for _, mod := range v.Modules {
+
+		module := &Repo{
+			Name:            mod.Name,
+			FullName:        mod.FullName,
+			HTMLURL:         mod.HTMLURL,
+			Description:     mod.Description,
+			StargazersCount: mod.StargazersCount,
+			ForksCount:      mod.ForksCount,
+			AvatarURL:       mod.Owner.AvatarURL,
+			Readme:          mod.Readme,
+		}
+
+		// counts of known modules are updated only when they are crawled
+		// themselves, so they never change without a snapshot
+		_, err := tx.Model(module).
+			OnConflict("(full_name) DO UPDATE").
+			Set("name = EXCLUDED.name").
+			Set("htmlurl = EXCLUDED.htmlurl").
+			Set("description = EXCLUDED.description").
+			Set("avatar_url = EXCLUDED.avatar_url").
+			Set("readme = EXCLUDED.readme").
+			Returning("id, stargazers_count, forks_count").
+			Insert()
+		if err != nil {
+			return fmt.Errorf("module %v: %v", mod.FullName, err)
+		}
+
+		if err := insertFirstSnapshot(tx, module); err != nil {
+			return fmt.Errorf("module %v snapshot: %v", mod.FullName, err)
+		}
+
+		repoToModule := &RepoToRepos{
+			RepoID:   repoID,
+			ModuleID: module.ID,
+			Version:  mod.Version,
+			Indirect: mod.Indirect,
+			Replace:  mod.Replace,
+			SeenAt:   time.Now(),
+		}
+
+		if err := upsertEdge(tx, repoToModule); err != nil {
+			return fmt.Errorf("edge to %v: %v", mod.FullName, err)
+		}
+
+		moduleIDs = append(moduleIDs, module.ID)
+	}
+
+	if !v.ModulesComplete {
+		return nil
+	}
+
+	if err := removeStaleEdges(tx, repoID, moduleIDs); err != nil {
+		return fmt.Errorf("stale edges: %v", err)
+	}
+	return nil
+}
+
+// upsertEdge updates version of existing repo to module edge or inserts a new one.
+func upsertEdge(tx *pg.Tx, edge *RepoToRepos) error {
+	_, err := tx.Model(edge).
+		OnConflict("(repo_id, module_id) DO UPDATE").
+		Set("version = EXCLUDED.version").
+		Set("indirect = EXCLUDED.indirect").
+		Set("replace = EXCLUDED.replace").
+		Set("seen_at = EXCLUDED.seen_at").
+		Insert()
+	return err
+}
+
+// removeStaleEdges deletes edges from repo with repoID to modules other than
+// moduleIDs and records them to RemovedEdge table.
+func removeStaleEdges(tx *pg.Tx, repoID int, moduleIDs []int) error {
+	stale := []RepoToRepos{}
+
+	query := tx.Model(&stale).Where("repo_id = ?", repoID)
+	if len(moduleIDs) > 0 {
+		query = query.Where("module_id NOT IN (?)", pg.In(moduleIDs))
+	}
+
+	if err := query.Select(); err != nil {
+		return err
+	}
+
+	if len(stale) == 0 {
+		return nil
+	}
+
+	removedAt := time.Now()
+	staleIDs := make([]int, 0, len(stale))
+
+	for _, edge := range stale {
+		removed := &RemovedEdge{
+			RepoID:    edge.RepoID,
+			ModuleID:  edge.ModuleID,
+			Version:   edge.Version,
+			Indirect:  edge.Indirect,
+			Replace:   edge.Replace,
+			SeenAt:    edge.SeenAt,
+			RemovedAt: removedAt,
+		}
+
+		if _, err := tx.Model(removed).Insert(); err != nil {
+			return err
+		}
+
+		staleIDs = append(staleIDs, edge.ModuleID)
+	}
+
+	_, err := tx.Model((*RepoToRepos)(nil)).
+		Where("repo_id = ?", repoID).
+		Where("module_id IN (?)", pg.In(staleIDs)).
+		Delete()
+
+	return err
+}
+
+const selectLimitOffsetQuery = `
+	SELECT "id", "name", "full_name", "description", "stargazers_count", "forks_count", "avatar_url"
+	FROM "repos"
+	ORDER BY "stargazers_count" DESC NULLS LAST
+	LIMIT ?0 OFFSET ?1
+`
+
+// SelectLimitOffset selects limit repos ordered by stars, skipping (page - 1) pages.
+func (p *Postgres) SelectLimitOffset(page, limit int) ([]Repo, error) {
+	var repos []Repo
+
+	_, err := p.db.Query(&repos, selectLimitOffsetQuery, limit, limit*(page-1))
+	return repos, err
+}
+
+const selectByIDQuery = `
+	SELECT "id", "name", "full_name", "htmlurl", "stargazers_count", "forks_count", "description", "avatar_url"
+	FROM "repos"
+	WHERE "id" = ?0
+`
+
+const selectByNameQuery = `
+	SELECT "id", "name", "full_name", "htmlurl", "stargazers_count", "forks_count", "description"
+	FROM "repos"
+	WHERE "name" = ?0
+	ORDER BY "stargazers_count" DESC NULLS LAST
+`
+
+// SelectByName selects repos with name, ordered by stars.
+func (p *Postgres) SelectByName(name string) ([]Repo, error) {
+	result := []Repo{}
+
+	_, err := p.db.Query(&result, selectByNameQuery, name)
+	return result, err
+}
+
+// SelectByID selects repo with id, without readme and modules.
+func (p *Postgres) SelectByID(id int) (Repo, error) {
+	var result Repo
+
+	_, err := p.db.QueryOne(&result, selectByIDQuery, id)
+	return result, err
+}
+
+// modulesTreeQuery selects modules of repo up to depth, walking edges
+// recursively. Edge back to a module already on the path, the repo itself
+// included, is not followed, so dependency cycles end. Each row is a module
+// reached by its path of ids from the repo, rows are ordered by depth and stars.
+const modulesTreeQuery = `
+	WITH RECURSIVE "tree" AS (
+		SELECT "edge"."repo_id", "edge"."module_id", "edge"."version", "edge"."indirect", "edge"."replace", "edge"."seen_at",
+			1 AS "depth", ARRAY["edge"."repo_id", "edge"."module_id"] AS "path"
+		FROM "repo_to_repos" AS "edge"
+		WHERE "edge"."repo_id" = ?0 AND "edge"."module_id" <> ?0
+		UNION ALL
+		SELECT "edge"."repo_id", "edge"."module_id", "edge"."version", "edge"."indirect", "edge"."replace", "edge"."seen_at",
+			"tree"."depth" + 1, "tree"."path" || "edge"."module_id"
+		FROM "repo_to_repos" AS "edge"
+		JOIN "tree" ON "edge"."repo_id" = "tree"."module_id"
+		WHERE "tree"."depth" < ?1 AND NOT "edge"."module_id" = ANY("tree"."path")
+	)
+	SELECT "repo"."id", "repo"."name", "repo"."full_name", "repo"."stargazers_count", "repo"."forks_count", "repo"."avatar_url", "repo"."description",
+		"tree"."repo_id", "tree"."version", "tree"."indirect", "tree"."replace", "tree"."seen_at", "tree"."path"
+	FROM "tree"
+	JOIN "repos" AS "repo" ON "repo"."id" = "tree"."module_id"
+	ORDER BY "tree"."depth", "repo"."stargazers_count" DESC NULLS LAST
+`
+
+// moduleNode is a module of the tree being assembled
+type moduleNode struct {
+	repo     Repo
+	children []*moduleNode
+}
+
+// QueryModules selects modules of repo with id, their modules and so on up to
+// level, 5 at most, with a single recursive query. Modules are ordered by stars.
+func (p *Postgres) QueryModules(id, level int) ([]Repo, error) {
+	if level < 1 {
+		level = 1
+	}
+	if level > 5 {
+		level = 5
+	}
+
+	rows := []moduleRow{}
+
+	_, err := p.db.Query(&rows, modulesTreeQuery, id, level)
+	if err != nil {
+		return nil, err
+	}
+
+	root := &moduleNode{}
+	nodes := map[string]*moduleNode{pathKey([]int{id}): root}
+
+	// parents precede their children, rows are ordered by depth
+	for _, row := range rows {
+		parent, ok := nodes[pathKey(row.Path[:len(row.Path)-1])]
+		if !ok {
+			continue
+		}
+
+		node := &moduleNode{repo: Repo{
+			ID:              row.ID,
+			Name:            row.Name,
+			FullName:        row.FullName,
+			StargazersCount: row.StargazersCount,
+			ForksCount:      row.ForksCount,
+			AvatarURL:       row.AvatarURL,
+			Description:     row.Description,
+			Edge: &RepoToRepos{
+				RepoID:   row.RepoID,
+				ModuleID: row.ID,
+				Version:  row.Version,
+				Indirect: row.Indirect,
+				Replace:  row.Replace,
+				SeenAt:   row.SeenAt,
+			},
+		}}
+
+		parent.children = append(parent.children, node)
+		nodes[pathKey(row.Path)] = node
+	}
+
+	return root.modules(level), nil
+}
+
+// modules returns children of node as repos nested down to level.
+// Modules of the last level are not set, the same as they are not selected.
+func (node *moduleNode) modules(level int) []Repo {
+	modules := make([]Repo, 0, len(node.children))
+	for _, child := range node.children {
+		module := child.repo
+		if level > 1 {
+			module.Modules = child.modules(level - 1)
+		}
+		modules = append(modules, module)
+	}
+
+	return modules
+}
+
+func pathKey(path []int) string {
+	return fmt.Sprint(path)
+}
+
+// searchQuery selects a page of repos matching tsquery ?0, ranked by
+// ts_rank of their search vector weighted by stars. Both simple and english
+// configurations are queried, so names match as typed and descriptions match
+// stemmed. Snippet is a headline of description or readme, whichever matches,
+// it is computed for the page only. Vectors of description and readme are
+// computed again for the page, the stored one doesn't tell where words matched.
+// Readme is stripped of tags and truncated as it is in the search vector, so
+// headlines don't cut tags.
+const searchQuery = `
+	WITH "q" AS (
+		SELECT to_tsquery('simple', ?0) || to_tsquery('english', ?0) AS "query"
+	), "found" AS (
+		SELECT "repo"."id",
+			ts_rank("repo"."search", "q"."query") * ln(2 + coalesce("repo"."stargazers_count", 0)) AS "rank",
+			count(*) OVER () AS "total"
+		FROM "repos" AS "repo", "q"
+		WHERE "repo"."search" @@ "q"."query"
+		ORDER BY "rank" DESC, "repo"."stargazers_count" DESC NULLS LAST, "repo"."id"
+		LIMIT ?1 OFFSET ?2
+	)
+	SELECT "repo"."id", "repo"."full_name", "repo"."avatar_url", "repo"."stargazers_count", "repo"."forks_count", "repo"."description",
+		"found"."total",
+		CASE
+			WHEN to_tsvector('english', coalesce("repo"."description", '')) @@ "q"."query"
+				THEN ts_headline('english', "repo"."description", "q"."query", ?3)
+			WHEN to_tsvector('english', "readme"."text") @@ "q"."query"
+				THEN ts_headline('english', "readme"."text", "q"."query", ?3)
+			ELSE coalesce("repo"."description", '')
+		END AS "snippet",
+		NOT to_tsvector('english', coalesce("repo"."description", '')) @@ "q"."query"
+			AND to_tsvector('english', "readme"."text") @@ "q"."query" AS "readme_snippet"
+	FROM "found"
+	JOIN "repos" AS "repo" ON "repo"."id" = "found"."id", "q",
+	LATERAL (
+		SELECT left(regexp_replace(coalesce("repo"."readme", ''), '<[^>]*>', ' ', 'g'), 100000) AS "text"
+	) AS "readme"
+	ORDER BY "found"."rank" DESC, "repo"."stargazers_count" DESC NULLS LAST, "repo"."id"
+`
+
+// searchRow is a row selected by searchQuery
+type searchRow struct {
+	ID              int
+	FullName        string
+	AvatarURL       string
+	StargazersCount int
+	ForksCount      int
+	Description     string
+	Total           int
+	Snippet         string
+	// ReadmeSnippet is set if snippet is a headline of readme text, its
+	// HTML entities are not unescaped yet
+	ReadmeSnippet bool
+}
+
+// Search selects limit repos matching all words of term as prefixes, skipping
+// (page - 1) pages, best matching and most starred first. Name matches weigh
+// more than description ones, description matches more than readme ones.
+// Count of matching repos is selected with the page, it is 0 past the last page.
+func (p *Postgres) Search(term string, page, limit int) ([]Repo, int, error) {
+	words := searchWords(term)
+	if len(words) == 0 {
+		return []Repo{}, 0, nil
+	}
+
+	rows := []searchRow{}
+
+	_, err := p.db.Query(&rows, searchQuery, prefixQuery(words), limit, limit*(page-1), headlineOptions)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total := 0
+	repos := make([]Repo, 0, len(rows))
+	for _, row := range rows {
+		total = row.Total
+
+		if row.ReadmeSnippet {
+			row.Snippet = readmeText(row.Snippet)
+		}
+
+		repos = append(repos, Repo{
+			ID:              row.ID,
+			FullName:        row.FullName,
+			AvatarURL:       row.AvatarURL,
+			StargazersCount: row.StargazersCount,
+			ForksCount:      row.ForksCount,
+			Description:     row.Description,
+			Snippet:         snippet(row.Snippet),
+		})
+	}
+
+	return repos, total, nil
+}
+
+// similarQuery selects repos which name or full name is similar to ?0 by
+// pg_trgm, above its similarity threshold, with similarity of both.
+const similarQuery = `
+	SELECT "id", "name", "full_name", "avatar_url", "stargazers_count", "forks_count", "description",
+		similarity("name", ?0) AS "name_similarity", similarity("full_name", ?0) AS "full_name_similarity"
+	FROM "repos"
+	WHERE "name" % ?0 OR "full_name" % ?0
+	ORDER BY greatest(similarity("name", ?0), similarity("full_name", ?0)) DESC, "stargazers_count" DESC NULLS LAST, "id"
+	LIMIT ?1
+`
+
+// similarRow is a row selected by similarQuery
+type similarRow struct {
+	ID                 int
+	Name               string
+	FullName           string
+	AvatarURL          string
+	StargazersCount    int
+	ForksCount         int
+	Description        string
+	NameSimilarity     float64
+	FullNameSimilarity float64
+}
+
+// SearchSimilar selects up to limit repos which name or full name is similar
+// to term by trigrams, most similar and most starred first. Suggestion is
+// name or full name of the first repo, whichever is more similar.
+func (p *Postgres) SearchSimilar(term string, limit int) ([]Repo, string, error) {
+	words := searchWords(term)
+	if len(words) == 0 {
+		return []Repo{}, "", nil
+	}
+
+	rows := []similarRow{}
+
+	_, err := p.db.Query(&rows, similarQuery, strings.Join(words, " "), limit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	suggestion := ""
+	repos := make([]Repo, 0, len(rows))
+	for i, row := range rows {
+		if i == 0 {
+			suggestion = row.FullName
+			if row.NameSimilarity >= row.FullNameSimilarity {
+				suggestion = row.Name
+			}
+		}
+
+		repos = append(repos, Repo{
+			ID:              row.ID,
+			FullName:        row.FullName,
+			AvatarURL:       row.AvatarURL,
+			StargazersCount: row.StargazersCount,
+			ForksCount:      row.ForksCount,
+			Description:     row.Description,
+		})
+	}
+
+	return repos, suggestion, nil
+}
+
+const selectReadmeQuery = `SELECT "readme" FROM "repos" WHERE "id" = ?0`
+
+const (
+	selectDependentIDsQuery = `SELECT DISTINCT "repo_id" FROM "repo_to_repos" WHERE "module_id" = ANY(?0)`
+
+	selectDependentsQuery = `
+		SELECT "id", "name", "full_name", "htmlurl", "stargazers_count", "forks_count", "description", "avatar_url"
+		FROM "repos"
+		WHERE "id" = ANY(?0)
+		ORDER BY "stargazers_count" DESC NULLS LAST
+	`
+)
+
+// SelectDependents selects repos depending on module with id, directly or
+// transitively up to level, with their distance to the module set as Depth.
+// Repos are ordered by stars.
+func (p *Postgres) SelectDependents(id, level int) ([]Repo, error) {
+	depths := map[int]int{id: 0}
+	frontier := []int{id}
+
+	for depth := 1; depth <= level && len(frontier) > 0; depth++ {
+		var repoIDs []int
+
+		_, err := p.db.Query(&repoIDs, selectDependentIDsQuery, pg.Array(frontier))
+		if err != nil {
+			return nil, err
+		}
+
+		frontier = []int{}
+		for _, repoID := range repoIDs {
+			if _, seen := depths[repoID]; !seen {
+				depths[repoID] = depth
+				frontier = append(frontier, repoID)
+			}
+		}
+	}
+
+	delete(depths, id)
+
+	result := []Repo{}
+
+	if len(depths) == 0 {
+		return result, nil
+	}
+
+	ids := make([]int, 0, len(depths))
+	for repoID := range depths {
+		ids = append(ids, repoID)
+	}
+
+	_, err := p.db.Query(&result, selectDependentsQuery, pg.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range result {
+		result[i].Depth = depths[result[i].ID]
+	}
+
+	return result, nil
+}
+
+// SelectReadme selects readme of repo with id
+func (p *Postgres) SelectReadme(id int) (string, error) {
+	var readme string
+
+	_, err := p.db.QueryOne(pg.Scan(&readme), selectReadmeQuery, id)
+	return readme, err
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-pg/pg/orm"
+)
+
+// queries are all read queries of Postgres with count of their parameters
+var queries = []struct {
+	name   string
+	query  string
+	params int
+}{
+	{"selectLimitOffsetQuery", selectLimitOffsetQuery, 2},
+	{"selectByIDQuery", selectByIDQuery, 1},
+	{"selectByNameQuery", selectByNameQuery, 1},
+	{"modulesTreeQuery", modulesTreeQuery, 2},
+	{"searchQuery", searchQuery, 4},
+	{"similarQuery", similarQuery, 2},
+	{"selectReadmeQuery", selectReadmeQuery, 1},
+	{"selectDependentIDsQuery", selectDependentIDsQuery, 1},
+	{"selectDependentsQuery", selectDependentsQuery, 1},
+	{"selectHistoryQuery", selectHistoryQuery, 3},
+	{"trendingQuery", trendingQuery, 2},
+	{"insertFirstSnapshotQuery", insertFirstSnapshotQuery, 4},
+}
+
+var (
+	fmtVerb     = regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z]`)
+	placeholder = regexp.MustCompile(`\?(\d*)`)
+)
+
+func TestQueriesBindParams(t *testing.T) {
+	for _, q := range queries {
+		if verb := fmtVerb.FindString(q.query); verb != "" {
+			t.Errorf("%s: fmt verb %q", q.name, verb)
+		}
+		if strings.Contains(q.query, "$") {
+			t.Errorf("%s: $ placeholder, go-pg binds ?N", q.name)
+		}
+
+		used := make(map[string]bool)
+		for _, match := range placeholder.FindAllStringSubmatch(q.query, -1) {
+			if match[1] == "" {
+				t.Errorf("%s: placeholder without index", q.name)
+				continue
+			}
+			used[match[1]] = true
+		}
+		if len(used) != q.params {
+			t.Errorf("%s: %d parameters bound, want %d", q.name, len(used), q.params)
+		}
+	}
+}
+
+// hostileValues are request values which must stay quoted literals
+var hostileValues = []string{
+	"1 OR 1=1",
+	"1;DROP TABLE repos",
+	"gin' OR '1'='1",
+	"gin'; DROP TABLE repos; --",
+	"gin\x00' OR 1=1 --",
+	"?0 ?1 $1 %s",
+}
+
+func TestQueriesQuoteHostileValues(t *testing.T) {
+	var f orm.Formatter
+
+	for _, value := range hostileValues {
+		for _, query := range []string{selectByNameQuery, similarQuery} {
+			sql := string(f.FormatQuery(nil, query, value, 10))
+
+			quoted := "'" + strings.Replace(strings.Replace(value, "\x00", "", -1), "'", "''", -1) + "'"
+			if !strings.Contains(sql, quoted) {
+				t.Errorf("value %q is not quoted as %s in\n%s", value, quoted, sql)
+			}
+		}
+	}
+}
+
+func TestQueriesBindIDs(t *testing.T) {
+	var f orm.Formatter
+
+	// ids passing parseID but past the end of the table, or of int4
+	for _, id := range []int{1, 2147483647, 2147483648, 9223372036854775807} {
+		for _, query := range []string{selectByIDQuery, selectReadmeQuery} {
+			sql := string(f.FormatQuery(nil, query, id))
+
+			if !strings.Contains(sql, `"id" = `+strconv.Itoa(id)) {
+				t.Errorf("id %d is not bound in\n%s", id, sql)
+			}
+		}
+	}
+}
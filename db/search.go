@@ -0,0 +1,151 @@
+package database
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 100
+
+	// snippetStart and snippetStop mark matched words in snippets selected
+	// from database. They are replaced with <mark> tags after the snippet
+	// is escaped, so repo text never reaches the response as HTML.
+	snippetStart = "\x01"
+	snippetStop  = "\x02"
+
+	// snippetWords is a maximum count of words in a snippet
+	snippetWords = 35
+
+	// readmeSearchLength is a count of characters of readme text searched,
+	// so search vector of a huge readme stays below tsvector size limit.
+	// Search column of migration 9 truncates readme to the same length.
+	readmeSearchLength = 100000
+)
+
+var (
+	// headlineOptions are ts_headline options of snippets
+	headlineOptions = "StartSel=" + snippetStart + ", StopSel=" + snippetStop + ", MinWords=15, MaxWords=35, ShortWord=2"
+
+	tagsRegexp = regexp.MustCompile(`<[^>]*>`)
+)
+
+// searchWords returns lower case words of term. Anything except letters and
+// digits separates words, so term can't carry tsquery operators.
+func searchWords(term string) []string {
+	return strings.FieldsFunc(strings.ToLower(term), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// prefixQuery returns tsquery text matching all words as prefixes,
+// e.g. `gorm:* & postgr:*`
+func prefixQuery(words []string) string {
+	parts := make([]string, 0, len(words))
+	for _, word := range words {
+		parts = append(parts, word+":*")
+	}
+	return strings.Join(parts, " & ")
+}
+
+// snippet returns text as HTML with matched words marked by snippetStart
+// and snippetStop wrapped in <mark> tags.
+func snippet(text string) string {
+	text = html.EscapeString(strings.Join(strings.Fields(text), " "))
+
+	text = strings.Replace(text, snippetStart, "<mark>", -1)
+	return strings.Replace(text, snippetStop, "</mark>", -1)
+}
+
+// readmeText returns searched text of readme HTML: tags are stripped, text
+// is truncated to readmeSearchLength characters and entities are unescaped.
+func readmeText(readme string) string {
+	text := []rune(tagsRegexp.ReplaceAllString(readme, " "))
+	if len(text) > readmeSearchLength {
+		text = text[:readmeSearchLength]
+	}
+	return html.UnescapeString(string(text))
+}
+
+// markWords returns snippet of text with words starting with any of
+// prefixes marked and ok true if any word is marked. Text longer than
+// snippetWords is cut around the first marked word.
+func markWords(text string, prefixes []string) (string, bool) {
+	words := strings.Fields(text)
+
+	first := -1
+	for i, word := range words {
+		if hasPrefix(searchWords(word), prefixes) {
+			words[i] = snippetStart + word + snippetStop
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+
+	if first < 0 {
+		return "", false
+	}
+
+	start := first - snippetWords/3
+	if start < 0 {
+		start = 0
+	}
+	end := start + snippetWords
+	if end > len(words) {
+		end = len(words)
+	}
+
+	return snippet(strings.Join(words[start:end], " ")), true
+}
+
+// hasPrefix reports whether any of words starts with any of prefixes
+func hasPrefix(words, prefixes []string) bool {
+	for _, word := range words {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(word, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// similarityThreshold is the default similarity threshold of pg_trgm
+const similarityThreshold = 0.3
+
+// trigrams returns set of trigrams of s the way pg_trgm does: each word of
+// letters and digits is lower cased and padded with two spaces in front and
+// one behind.
+func trigrams(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range searchWords(s) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+// similarity returns count of trigrams shared by a and b divided by count
+// of their distinct trigrams, as pg_trgm `similarity` does.
+func similarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+
+	all := len(ta) + len(tb) - shared
+	if all == 0 {
+		return 0
+	}
+	return float64(shared) / float64(all)
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestReadmeText(t *testing.T) {
+	text := readmeText(`<h1 id="gin">Gin</h1><p>Fast &amp; <a href="https://gin-gonic.com">small</a></p>`)
+	if got, want := strings.Join(strings.Fields(text), " "), "Gin Fast & small"; got != want {
+		t.Errorf("text %q, want %q", got, want)
+	}
+
+	long := readmeText("<p>" + strings.Repeat("é", readmeSearchLength*2) + "</p>")
+	if n := len([]rune(long)); n != readmeSearchLength {
+		t.Errorf("text of %d characters, want %d", n, readmeSearchLength)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	// values are computed by pg_trgm `similarity`
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"gorila mux", "gorilla/mux", 10.0 / 13},
+		{"word", "two words", 4.0 / 11},
+		{"gin", "gin", 1},
+		{"Gin", "gin", 1},
+		{"gin", "echo", 0},
+		{"", "gin", 0},
+		{"", "", 0},
+	}
+
+	for _, test := range tests {
+		if got := similarity(test.a, test.b); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("similarity(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+		if got := similarity(test.b, test.a); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("similarity(%q, %q) = %v, want %v", test.b, test.a, got, test.want)
+		}
+	}
+}
+
+func TestTrigrams(t *testing.T) {
+	got := trigrams("Go-Mux")
+	want := []string{"  g", " go", "go ", "  m", " mu", "mux", "ux "}
+
+	if len(got) != len(want) {
+		t.Errorf("trigrams %v, want %v", got, want)
+	}
+	for _, trigram := range want {
+		if !got[trigram] {
+			t.Errorf("trigram %q missing in %v", trigram, got)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package database
+
+import (
+	"time"
+
+	"github.com/a-sube/go-repos-api/structs"
+)
+
+// Storage stores crawled repos and their modules. Package level functions
+// use Store.
+type Storage interface {
+	// Insert inserts or updates repo v, its modules and edges to them atomically.
+	Insert(v structs.Item) error
+	// SelectLimitOffset selects limit repos ordered by stars, skipping (page - 1) pages.
+	SelectLimitOffset(page, limit int) ([]Repo, error)
+	// SelectByName selects repos with name, ordered by stars.
+	SelectByName(name string) ([]Repo, error)
+	// SelectByID selects repo with id, without readme and modules.
+	SelectByID(id int) (Repo, error)
+	// QueryModules selects modules of repo with id up to level, 5 at most.
+	QueryModules(id, level int) ([]Repo, error)
+	// Search selects limit repos matching all words of term as prefixes, skipping
+	// (page - 1) pages, with snippets of matched text. Returns count of all matching repos.
+	Search(term string, page, limit int) ([]Repo, int, error)
+	// SearchSimilar selects up to limit repos which name or full name is similar
+	// to term by trigrams, most similar first, and the most similar name as a suggestion.
+	SearchSimilar(term string, limit int) ([]Repo, string, error)
+	// SelectReadme selects readme of repo with id.
+	SelectReadme(id int) (string, error)
+	// SelectDependents selects repos depending on module with id, directly or
+	// transitively up to level, with Depth set. Repos are ordered by stars.
+	SelectDependents(id, level int) ([]Repo, error)
+	// SelectHistory selects snapshots of repo with id taken from from until to, ordered by time.
+	SelectHistory(id int, from, to time.Time) ([]RepoSnapshot, error)
+	// SelectTrending selects up to limit repos ranked by stars gained since.
+	SelectTrending(since time.Time, limit int) ([]Repo, error)
+}
+
+// Store is the storage used by package level functions, PostgreSQL by default.
+// Set it to NewMemory() to run without a database.
+var Store Storage = NewPostgres(DB)
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	client "github.com/a-sube/go-repos-api/gh-client"
+	"github.com/a-sube/go-repos-api/utils"
+	"github.com/go-redis/redis"
+)
+
+// cachePrefix followed by request key is a cached GitHub response
+const cachePrefix = "farmer:etag:"
+
+// cacheTTL is how long cached responses are kept. It is twice the longest
+// refresh interval, so a response is still cached when a repo is crawled
+// again later than due because of the refresh budget.
+func cacheTTL() time.Duration {
+	return 2 * *refreshEvery
+}
+
+// redisCache stores GitHub responses with their ETag and Last-Modified
+// validators in redis, so conditional requests survive restarts.
+type redisCache struct{}
+
+func (redisCache) Get(key string) (client.CachedResponse, bool) {
+	var resp client.CachedResponse
+
+	data, err := redisClient.Get(cachePrefix + key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			utils.HandleErrLog(err, "REDIS GET CACHE")
+		}
+		return resp, false
+	}
+
+	if err := json.Unmarshal(data, &resp); err != nil {
+		utils.HandleErrLog(err, "CACHE UNMARSHAL")
+		return resp, false
+	}
+
+	return resp, true
+}
+
+func (redisCache) Set(key string, resp client.CachedResponse) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		utils.HandleErrLog(err, "CACHE MARSHAL")
+		return
+	}
+
+	_, err = redisClient.Set(cachePrefix+key, data, cacheTTL()).Result()
+	utils.HandleErrLog(err, "REDIS SET CACHE")
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/a-sube/go-repos-api/structs"
+	"github.com/a-sube/go-repos-api/utils"
+	"github.com/go-redis/redis"
+)
+
+// Partitioned discovery slices the search into partitions small enough to be
+// fully enumerated within the 1000 results cap of the search API. A partition
+// having more results is split by stars first, then by `created:` and finally
+// by `pushed:` date windows.
+//
+// partitionsKey is a list of partitions not searched yet in current discovery.
+// leavesKey is a list of partitions fully enumerated in current discovery.
+// planKey is a JSON list of leaves of the last finished discovery, next
+// discovery starts from it instead of splitting from scratch.
+const (
+	partitionsKey = "farmer:partitions"
+	leavesKey     = "farmer:partition-leaves"
+	planKey       = "farmer:partition-plan"
+
+	searchCap     = 1000
+	searchPerPage = 100
+	dateLayout    = "2006-01-02"
+)
+
+var (
+	discovery = flag.String("discovery", "top", "repos discovery `mode`: top (1000 most starred repos) or partitioned (all repos with -min-stars)")
+
+	minStars = flag.Int("min-stars", 10, "partitioned discovery skips repos with less than `n` stars")
+
+	// githubLaunch is the earliest creation date of a repo
+	githubLaunch = time.Date(2008, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// dateRange is an inclusive range of days. Zero range is not restricted,
+// zero To is not bounded so a stored plan covers repos created or pushed later.
+type dateRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+func (r dateRange) isSet() bool {
+	return !r.From.IsZero()
+}
+
+func (r dateRange) String() string {
+	if r.To.IsZero() {
+		return ">=" + r.From.Format(dateLayout)
+	}
+	return r.From.Format(dateLayout) + ".." + r.To.Format(dateLayout)
+}
+
+// split halves range, false if range is a single day
+func (r dateRange) split() (dateRange, dateRange, bool) {
+	to := r.To
+	if to.IsZero() {
+		to = time.Now().UTC().Truncate(time.Hour * 24)
+	}
+
+	days := int(to.Sub(r.From).Hours() / 24)
+	if days < 1 {
+		return r, r, false
+	}
+
+	mid := r.From.AddDate(0, 0, days/2)
+	return dateRange{r.From, mid}, dateRange{mid.AddDate(0, 0, 1), r.To}, true
+}
+
+// partition is a single slice of the search. MaxStars < 0 is not bounded.
+type partition struct {
+	MinStars int       `json:"min_stars"`
+	MaxStars int       `json:"max_stars"`
+	Created  dateRange `json:"created"`
+	Pushed   dateRange `json:"pushed"`
+}
+
+func (p partition) query() string {
+	q := "language:go"
+
+	if p.MaxStars < 0 {
+		q += fmt.Sprintf(" stars:>=%d", p.MinStars)
+	} else {
+		q += fmt.Sprintf(" stars:%d..%d", p.MinStars, p.MaxStars)
+	}
+
+	if p.Created.isSet() {
+		q += " created:" + p.Created.String()
+	}
+	if p.Pushed.isSet() {
+		q += " pushed:" + p.Pushed.String()
+	}
+
+	return q
+}
+
+// split returns two partitions covering p, false if p can't be split.
+// Unbounded star range is split at the doubled lower bound.
+func (p partition) split() (partition, partition, bool) {
+	a, b := p, p
+
+	switch {
+	case p.MaxStars < 0:
+		mid := p.MinStars * 2
+		if mid <= p.MinStars {
+			mid = p.MinStars + 1
+		}
+		a.MaxStars, b.MinStars = mid-1, mid
+		return a, b, true
+
+	case p.MinStars < p.MaxStars:
+		mid := p.MinStars + (p.MaxStars-p.MinStars)/2
+		a.MaxStars, b.MinStars = mid, mid+1
+		return a, b, true
+	}
+
+	if !p.Created.isSet() {
+		p.Created = dateRange{From: githubLaunch}
+	}
+
+	if created1, created2, ok := p.Created.split(); ok {
+		a, b = p, p
+		a.Created, b.Created = created1, created2
+		return a, b, true
+	}
+
+	// repo is pushed at or after it is created
+	if !p.Pushed.isSet() {
+		p.Pushed = dateRange{From: p.Created.From}
+	}
+
+	if pushed1, pushed2, ok := p.Pushed.split(); ok {
+		a, b = p, p
+		a.Pushed, b.Pushed = pushed1, pushed2
+		return a, b, true
+	}
+
+	return p, p, false
+}
+
+// discoverPartitioned stores all repos with at least -min-stars to redis. An
+// interrupted discovery continues from the stored partitions.
+func discoverPartitioned() {
+
+	if !discoveryInProgress() {
+		startDiscovery(loadPlan())
+	}
+
+	for !stopped() {
+		p, ok := nextPartition()
+		if !ok {
+			break
+		}
+
+		if !searchPartition(p) {
+			return
+		}
+
+		donePartition()
+	}
+
+	if stopped() {
+		return
+	}
+
+	savePlan()
+}
+
+// searchPartition stores repos of p to redis or, if p has too many results,
+// queues its halves. Returns false if stopped.
+func searchPartition(p partition) bool {
+
+	body, err := searchPage(p, 1)
+	if err != nil {
+		// keep partition in the plan, it is searched again next discovery
+		utils.HandleErrLog(err, "PARTITION SEARCH ERR "+p.query())
+		addLeaf(p)
+		return true
+	}
+
+	if body.TotalCount > searchCap {
+		if a, b, ok := p.split(); ok {
+			pushPartitions(a, b)
+			return true
+		}
+		log.Printf("PARTITION %q HAS %d REPOS, ONLY %d ARE REACHABLE\n", p.query(), body.TotalCount, searchCap)
+	}
+
+	body.StoreToRedis()
+
+	total := body.TotalCount
+	if total > searchCap {
+		total = searchCap
+	}
+
+	pages := (total + searchPerPage - 1) / searchPerPage
+	for page := 2; page <= pages; page++ {
+		if stopped() {
+			return false
+		}
+
+		body, err := searchPage(p, page)
+		if err != nil {
+			utils.HandleErrLog(err, "PARTITION SEARCH ERR "+p.query()+", PAGE "+utils.IntToStr(page))
+			continue
+		}
+		body.StoreToRedis()
+	}
+
+	addLeaf(p)
+	return true
+}
+
+func searchPage(p partition, page int) (structs.Body, error) {
+	var body structs.Body
+
+	req, err := gh.Request(
+		"GET",
+		"/search/repositories",
+		"q="+url.QueryEscape(p.query())+"&sort=stars&order=desc&per_page="+utils.IntToStr(searchPerPage)+"&page="+utils.IntToStr(page),
+		nil,
+	)
+	if err != nil {
+		return body, err
+	}
+
+	_, err = gh.DoJson(req, &body)
+	return body, err
+}
+
+// discoveryInProgress reports whether there are partitions left from interrupted discovery
+func discoveryInProgress() bool {
+	n, err := redisClient.Exists(partitionsKey).Result()
+	utils.HandleErrPANIC(err, "REDIS EXISTS PARTITIONS")
+
+	return n > 0
+}
+
+// startDiscovery stores partitions of a new discovery
+func startDiscovery(plan []partition) {
+	_, err := redisClient.Del(partitionsKey, leavesKey).Result()
+	utils.HandleErrPANIC(err, "REDIS DEL DISCOVERY")
+
+	pushPartitions(plan...)
+}
+
+// loadPlan returns leaves of the last discovery or a single partition of all
+// repos with -min-stars if there is no plan yet
+func loadPlan() []partition {
+	initial := []partition{{MinStars: *minStars, MaxStars: -1}}
+
+	data, err := redisClient.Get(planKey).Bytes()
+	if err == redis.Nil {
+		return initial
+	}
+	utils.HandleErrPANIC(err, "REDIS GET PLAN")
+
+	var plan []partition
+	utils.HandleErrPANIC(json.Unmarshal(data, &plan), "PLAN UNMARSHAL")
+
+	// plan of another -min-stars doesn't cover the same repos
+	if len(plan) == 0 || lowestStars(plan) != *minStars {
+		return initial
+	}
+
+	return plan
+}
+
+func lowestStars(plan []partition) int {
+	lowest := plan[0].MinStars
+	for _, p := range plan {
+		if p.MinStars < lowest {
+			lowest = p.MinStars
+		}
+	}
+	return lowest
+}
+
+// savePlan stores leaves of finished discovery as the plan of the next one
+func savePlan() {
+	values, err := redisClient.LRange(leavesKey, 0, -1).Result()
+	utils.HandleErrPANIC(err, "REDIS GET LEAVES")
+
+	plan := make([]partition, len(values))
+	for i, value := range values {
+		utils.HandleErrPANIC(json.Unmarshal([]byte(value), &plan[i]), "LEAF UNMARSHAL")
+	}
+
+	data, err := json.Marshal(plan)
+	utils.HandleErrPANIC(err, "PLAN MARSHAL")
+
+	_, err = redisClient.Set(planKey, data, 0).Result()
+	utils.HandleErrPANIC(err, "REDIS SET PLAN")
+
+	_, err = redisClient.Del(leavesKey).Result()
+	utils.HandleErrPANIC(err, "REDIS DEL LEAVES")
+
+	log.Printf("DISCOVERY DONE! PARTITIONS: %d\n", len(plan))
+}
+
+// nextPartition returns partition to search. It stays in the list until
+// donePartition is called.
+func nextPartition() (partition, bool) {
+	var p partition
+
+	value, err := redisClient.LIndex(partitionsKey, 0).Result()
+	if err == redis.Nil {
+		return p, false
+	}
+	utils.HandleErrPANIC(err, "REDIS NEXT PARTITION")
+
+	utils.HandleErrPANIC(json.Unmarshal([]byte(value), &p), "PARTITION UNMARSHAL")
+	return p, true
+}
+
+func donePartition() {
+	_, err := redisClient.LPop(partitionsKey).Result()
+	utils.HandleErrPANIC(err, "REDIS POP PARTITION")
+}
+
+// pushPartitions queues partitions at the tail
+func pushPartitions(partitions ...partition) {
+	if len(partitions) == 0 {
+		return
+	}
+
+	values := make([]interface{}, len(partitions))
+	for i, p := range partitions {
+		data, err := json.Marshal(p)
+		utils.HandleErrPANIC(err, "PARTITION MARSHAL")
+		values[i] = data
+	}
+
+	_, err := redisClient.RPush(partitionsKey, values...).Result()
+	utils.HandleErrPANIC(err, "REDIS PUSH PARTITIONS")
+}
+
+func addLeaf(p partition) {
+	data, err := json.Marshal(p)
+	utils.HandleErrPANIC(err, "LEAF MARSHAL")
+
+	_, err = redisClient.RPush(leavesKey, data).Result()
+	utils.HandleErrPANIC(err, "REDIS PUSH LEAF")
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
@@ -9,62 +10,182 @@ import (
 	"time"
 
 	"log"
-	"regexp"
+	"net/http"
+	"net/url"
 	"strings"
 	"sync"
 
 	database "github.com/a-sube/go-repos-api/db"
 	client "github.com/a-sube/go-repos-api/gh-client"
+	"github.com/a-sube/go-repos-api/gh-client/fakegh"
 
+	"github.com/a-sube/go-repos-api/gomod"
+	"github.com/a-sube/go-repos-api/goproxy"
 	"github.com/a-sube/go-repos-api/structs"
 	"github.com/a-sube/go-repos-api/utils"
+	"github.com/a-sube/go-repos-api/vanity"
 	"github.com/go-redis/redis"
 )
 
 var (
 	gh = client.GH
 
+	// proxy resolves modules not hosted on github, nil if disabled
+	proxy *goproxy.Client
+
+	// vanityResolver maps vanity import paths to repositories
+	vanityResolver = vanity.NewResolver(nil)
+
 	redisClient = redis.NewClient(&redis.Options{
 		Addr:     "localhost:6379",
 		Password: "",
 		DB:       0,
 	})
 
-	queryParameter = "q=go+package+in:readme+language:go&sort=stars&order=desc&page="
+	// stop is closed on SIGINT, crawling stops after current repo
+	stop = make(chan struct{})
+
+	// insertRetries is how many times a failed DB insert is tried
+	insertRetries = 3
+
+	// requeueRetries is how many times a queued repo failed to be stored is queued again
+	requeueRetries = 3
+
+	useGraphQL = flag.Bool("graphql", true, "fetch dependencies metadata and go.mod with batched GraphQL queries")
+
+	workers = flag.Int("workers", 4, "`number` of repos crawled and requests sent concurrently")
+
+	visitedTTL = flag.Duration("visited-ttl", time.Hour*6, "crawled repo is not fetched again for `duration`, across all roots")
+
+	fakeGitHub = flag.String("fake-github", "", "serve GitHub API in-process from fixtures `dir` instead of api.github.com")
 )
 
 func main() {
 
-	utils.CheckEnvVars(true, true, true)
+	flag.Parse()
+
+	if *workers < 1 {
+		*workers = 1
+	}
+	slots = make(chan struct{}, *workers)
+
+	if *refreshBudget < 1 {
+		*refreshBudget = 1
+	}
+
+	utils.CheckEnvVars(true, true, *fakeGitHub == "", false)
+
+	if *fakeGitHub != "" {
+		server := fakegh.NewServer()
+		defer server.Close()
+
+		utils.HandleErrEXIT(server.LoadDir(*fakeGitHub), "FAKE GITHUB LOAD")
+		log.Printf("FAKE GITHUB: %s\n", server.URL)
+
+		fake, err := client.NewGitHubClient(server.URL, "", nil)
+		utils.HandleErrEXIT(err, "FAKE GITHUB CLIENT")
+		gh = fake
+	} else if utils.GITHUBURL != "" {
+		custom, err := client.NewGitHubClientWithTokens(utils.GITHUBURL, utils.AccessTokens(), nil)
+		utils.HandleErrEXIT(err, "GITHUB CLIENT")
+		gh = custom
+	}
+
+	gh.SetCache(redisCache{})
+
+	switch {
+	case *seedsFile != "":
+		loaded, err := loadSeeds(*seedsFile)
+		utils.HandleErrEXIT(err, "SEEDS")
+		seeds = loaded
+	case *discovery != "partitioned":
+		seeds = defaultSeeds
+	}
+
+	proxyURL := goproxy.DefaultURL
+	if utils.GOPROXY != "" {
+		proxyURL = utils.GOPROXY
+	}
+
+	p, proxyErr := goproxy.New(proxyURL)
+	utils.HandleErrLog(proxyErr, "GOPROXY: modules not hosted on github are skipped")
+	proxy = p
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt)
 
 	go func() {
 		s := <-sigs
+		log.Printf("RECEIVED SIGNAL: %s. FINISHING CURRENT REPO, SEND AGAIN TO EXIT NOW", s)
+		close(stop)
+
+		s = <-sigs
 		log.Printf("RECEIVED SIGNAL: %s", s)
 		os.Exit(1)
 	}()
 
-	database.CreateSchema()
+	utils.HandleErrEXIT(database.CheckMigrations(), "DB MIGRATIONS")
 
-	sendTenRequests()
+	schedule()
+
+	log.Println("STOPPED")
 }
 
-func sendTenRequests() {
+// stopped reports whether farmer received a signal to stop
+func stopped() bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep sleeps for d. Returns false if woken up by a stop signal.
+func sleep(d time.Duration) bool {
+	select {
+	case <-stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// discoverRepos stores repos of all seeds and, in partitioned mode, all
+// repos found by partitioned search to redis
+func discoverRepos() {
+	discoverSeeds()
+
+	if *discovery == "partitioned" {
+		discoverPartitioned()
+	}
+}
+
+// sendSearchRequests stores up to max repos found with query, sorted by
+// stars. Pages are requested concurrently, repos of the last page past max
+// are not stored.
+func sendSearchRequests(query string, max int) {
+
+	if max <= 0 || max > searchCap {
+		max = searchCap
+	}
 
 	wg := &sync.WaitGroup{}
-	for i := 1; i <= 10; i++ {
+	for page := 1; page <= (max+searchPerPage-1)/searchPerPage; page++ {
+		keep := max - (page-1)*searchPerPage
+		if keep > searchPerPage {
+			keep = searchPerPage
+		}
+
 		wg.Add(1)
-		go sendSingleRequest(i, wg)
+		go sendSingleRequest(query, page, keep, wg)
 	}
 	wg.Wait()
 
-	startDependencySearch()
-
 }
 
-func sendSingleRequest(page int, wg *sync.WaitGroup) {
+// sendSingleRequest stores the first keep repos of search results page
+func sendSingleRequest(query string, page, keep int, wg *sync.WaitGroup) {
 
 	defer wg.Done()
 
@@ -73,75 +194,159 @@ func sendSingleRequest(page int, wg *sync.WaitGroup) {
 	req, reqErr := gh.Request(
 		"GET",
 		"/search/repositories",
-		queryParameter+utils.IntToStr(page)+"&per_page=100",
+		"q="+url.QueryEscape(query)+"&sort=stars&order=desc&page="+utils.IntToStr(page)+"&per_page="+utils.IntToStr(searchPerPage),
 		nil,
 	)
-	utils.HandleErrEXIT(reqErr, "REQ ERR")
+	if reqErr != nil {
+		utils.HandleErrLog(reqErr, "SEARCH REQ ERR")
+		return
+	}
 
 	_, respErr := gh.DoJson(req, &body)
-	utils.HandleErrEXIT(respErr, "RESP ERR")
+	if respErr != nil {
+		utils.HandleErrLog(respErr, "SEARCH RESP ERR, PAGE "+utils.IntToStr(page))
+		return
+	}
+
+	if len(body.Items) > keep {
+		body.Items = body.Items[:keep]
+	}
 
 	body.StoreToRedis()
 }
 
-func startDependencySearch() {
+// runBFSlike crawls dependencies of root key. BFS queue is stored in redis,
+// an interrupted crawl continues from the queue head. Up to -workers queued
+// repos are crawled concurrently. Repos are queued only when discovered first
+// time within -visited-ttl, from any root, and crawled last time longer than
+// their refresh interval ago. Every crawled repo spends -refresh-budget.
+// Returns false if root could not be stored.
+func runBFSlike(key string) bool {
+
+	if !resumedRoot(key) {
+		if _, ok := visitedItem(key); ok {
+			return true
+		}
 
-	keys, _ := redisClient.HKeys("go-api").Result()
+		if last, ok := lastCrawled(key); ok {
+			log.Printf("REFRESHING %s, LAST CRAWLED %s AGO\n", key, time.Since(last).Round(time.Minute))
+		}
 
-	for _, key := range keys {
-		runBFSlike(key)
+		if !spendBudget() {
+			return true
+		}
+
+		item, _ := getItemFromRedis(key)
+		rawFiles, fetched := getGoMod(&item)
+
+		modules, discovered, complete := getModules(rawFiles, key)
+		item.Modules = modules
+		item.ModulesComplete = fetched && complete
+		item.SetReadme(getReadmeHTML(key))
+
+		item.Normalize()
+
+		if !insertItem(item) {
+			unmarkVisited(discovered)
+			return false
+		}
+		markVisited(item)
+		markCrawled(key)
+
+		startRoot(key, staleItems(discovered))
 	}
 
-	log.Printf("CYCLE DONE! REQUESTS MADE: %d\n", gh.RequestsMade())
+	for !stopped() {
+		batch := peekItems(*workers)
+		if len(batch) == 0 {
+			break
+		}
+
+		for range batch {
+			if !spendBudget() {
+				return true
+			}
+		}
 
-	keys = []string{}
+		found := make([][]*structs.Item, len(batch))
 
-	time.Sleep(time.Hour * 6)
-	gh.Reset()
-	sendTenRequests()
-}
+		wg := &sync.WaitGroup{}
+		for i, childItem := range batch {
+			wg.Add(1)
 
-func runBFSlike(key string) {
+			go func(i int, childItem *structs.Item) {
+				defer wg.Done()
+				found[i] = crawlItem(childItem)
+			}(i, childItem)
+		}
+		wg.Wait()
 
-	item, _ := getItemFromRedis(key)
-	rawFiles, err := gh.GetRawContent("/repos/" + key + "/contents/go.mod")
-	utils.HandleErrPANIC(err, "GetRawContent")
+		for _, discovered := range found {
+			pushItems(staleItems(discovered))
+		}
+		popItems(len(batch))
+	}
 
-	modules := getModules(rawFiles, key)
-	item.Modules = modules
-	item.SetReadme(getReadmeHTML(key))
+	return true
+}
 
-	item.Normalize()
+// crawlItem gets go.mod of queued item, inserts item with its modules.
+// Returns modules discovered first time. If item fails to be stored, its
+// modules are not queued and item itself is returned to be queued again,
+// up to requeueRetries times.
+func crawlItem(childItem *structs.Item) []*structs.Item {
+	childRawFiles, fetched := getGoMod(childItem)
 
-	database.Insert(item)
+	childModules, discovered, complete := getModules(childRawFiles, childItem.FullName)
+	childItem.Modules = childModules
+	childItem.ModulesComplete = fetched && complete
 
-	seen := make(map[string]bool)
+	if !childItem.ReadmeIsSet {
+		childItem.SetReadme(getReadmeHTML(childItem.FullName))
+	}
 
-	for len(modules) > 0 {
-		childItem := modules[0]
+	childItem.Normalize()
 
-		if _, ok := seen[childItem.FullName]; !ok {
-			childRawFiles, err := gh.GetRawContent("/repos/" + childItem.FullName + "/contents/go.mod")
-			utils.HandleErrPANIC(err, "GetRawContent")
+	if insertItem(*childItem) {
+		markCrawled(childItem.FullName)
+		return discovered
+	}
 
-			childModules := getModules(childRawFiles, childItem.FullName)
-			childItem.Modules = childModules
+	unmarkVisited(discovered)
 
-			if !childItem.ReadmeIsSet {
-				childItem.SetReadme(getReadmeHTML(childItem.FullName))
-			}
+	childItem.InsertFailures++
+	if childItem.InsertFailures > requeueRetries {
+		log.Printf("%s SKIPPED, FAILED TO BE STORED %d TIMES\n", childItem.FullName, childItem.InsertFailures)
+		unmarkVisited([]*structs.Item{childItem})
+		return nil
+	}
 
-			childItem.Normalize()
+	// go.mod is kept, modules are fetched again when item is crawled again
+	childItem.Modules = nil
+	if fetched {
+		childItem.SetGoMod(childRawFiles)
+	}
 
-			database.Insert(*childItem)
+	return []*structs.Item{childItem}
+}
 
-			seen[childItem.FullName] = true
+// insertItem stores item with its modules to DB. Failed insert is retried
+// with backoff, after insertRetries failures item is skipped.
+func insertItem(item structs.Item) bool {
+	backoff := time.Second
 
-			modules = append(modules, childModules...)
+	for attempt := 1; ; attempt++ {
+		err := database.Insert(item)
+		if err == nil {
+			return true
 		}
 
-		modules = modules[1:]
+		utils.HandleErrLog(err, "DB INSERT, ATTEMPT "+utils.IntToStr(attempt))
 
+		if attempt == insertRetries || !sleep(backoff) {
+			return false
+		}
+		backoff *= 2
 	}
 }
 
@@ -166,47 +371,245 @@ func getItemFromRedis(key string) (structs.Item, error) {
 	return item, nil
 }
 
-// getModules takes string input (example: https://github.com/hashicorp/consul/blob/master/go.mod). Returns slice of
-// key - owner/repo format. (example: hashicorp/consul)
-func getModules(input string, key string) []*structs.Item {
+// getGoMod returns raw go.mod of item. Prefetched go.mod is used if set. GitHub repos are
+// read from default branch, modules resolved through proxy are read at their latest version.
+// Returns false if go.mod could not be fetched. Only a go.mod served with `200 OK` or
+// `404 Not Found` of go.mod itself, the repo has none, is authoritative.
+func getGoMod(item *structs.Item) (string, bool) {
+	if item.GoModIsSet {
+		return item.GoMod, true
+	}
+
+	if item.ModulePath != "" {
+		if proxy == nil {
+			return "", false
+		}
+
+		var mod string
+		var err error
+		limited(func() {
+			_, mod, err = proxy.LatestMod(item.ModulePath)
+		})
+		utils.HandleErrLog(err, "GOPROXY MOD")
+		return mod, err == nil
+	}
+
+	key := strings.ToLower(item.FullName)
+
+	var rawFiles string
+	var err error
+	limited(func() {
+		rawFiles, err = gh.GetRawContent("/repos/" + key + "/contents/go.mod")
+	})
+	if client.IsNotFound(err) {
+		return "", true
+	}
+	if err != nil {
+		utils.HandleErrLog(err, "GetRawContent "+key)
+		return "", false
+	}
+
+	return rawFiles, true
+}
+
+// getModules takes raw go.mod content (example: https://github.com/hashicorp/consul/blob/master/go.mod).
+// Returns items for required modules with required version, indirect flag and replacement set.
+// Modules hosted on github, directly or behind a vanity import path, are keyed by `owner/repo`,
+// others are resolved through the module proxy.
+// Modules not visited before are fetched concurrently, marked visited and returned in discovered too.
+// complete is false if some requirement was skipped or failed to fetch.
+func getModules(input string, key string) (result []*structs.Item, discovered []*structs.Item, complete bool) {
 
-	result := []*structs.Item{}
+	result = []*structs.Item{}
+	discovered = []*structs.Item{}
 
-	if !strings.HasPrefix(input, `{"message":"Not Found"`) {
+	if strings.HasPrefix(input, `{"message":"Not Found"`) {
+		return result, discovered, true
+	}
 
-		set := make(map[string]bool)
-		// grab only modules that start with pattern `github.com/<owner>/<repo>`
-		regex := regexp.MustCompile(`(^.*)?github\.com\/([-_\w]+\/[-_\w]+)`)
+	file, parseErr := gomod.Parse(input)
+	if parseErr != nil {
+		utils.HandleErrLog(parseErr, "GO.MOD PARSE "+key)
+		return result, discovered, false
+	}
+
+	complete = true
 
-		for _, line := range strings.Split(input, "\n") {
-			line = strings.TrimSpace(line)
+	requires := make(map[string]gomod.Require)
+	onGitHub := make(map[string]bool)
+	order := []string{}
 
-			if strings.HasPrefix(line, "module") {
+	for _, req := range file.Require {
+		dep, ok := resolveGitHubRepo(req.Path)
+		if !ok {
+			if proxy == nil {
+				complete = false
 				continue
 			}
+			dep = strings.ToLower(req.Path)
+		}
 
-			matches := regex.FindStringSubmatch(line)
+		// skip the repo itself
+		if dep == key || req.Path == file.Module {
+			continue
+		}
 
-			// if found matches and matched string is not a repo itself
-			// add to set
-			if len(matches) > 0 && matches[2] != key {
-				set[matches[2]] = true
-			}
+		prev, seen := requires[dep]
+		if !seen {
+			order = append(order, dep)
+			onGitHub[dep] = ok
 		}
 
-		for key := range set {
-			item, itemErr := createItem(strings.ToLower(key))
-			if key == "" || itemErr != nil {
-				continue
-			} else {
-				item.SetReadme(getReadmeHTML(key))
-				item.Normalize()
-				result = append(result, &item)
+		// several modules may live in one repo, direct requirement wins
+		if !seen || (prev.Indirect && !req.Indirect) {
+			requires[dep] = req
+		}
+	}
+
+	infos := prefetchRepos(order, onGitHub)
+
+	items := make([]*structs.Item, len(order))
+	fresh := make([]bool, len(order))
+
+	wg := &sync.WaitGroup{}
+	for i, dep := range order {
+		wg.Add(1)
+
+		go func(i int, dep string) {
+			defer wg.Done()
+
+			req := requires[dep]
+
+			var info *client.RepoInfo
+			if prefetched, ok := infos[dep]; ok {
+				info = &prefetched
 			}
+
+			item, isNew, itemErr := fetchDep(dep, req, onGitHub[dep], info)
+			if itemErr != nil {
+				utils.HandleErrLog(itemErr, "DEPENDENCY "+dep)
+				return
+			}
+
+			item.Version = req.Version
+			item.Indirect = req.Indirect
+			if replace := file.Replacement(req.Path, req.Version); replace != nil {
+				item.Replace = replace.New.String()
+			}
+
+			items[i] = &item
+			fresh[i] = isNew
+		}(i, dep)
+	}
+	wg.Wait()
+
+	for i, item := range items {
+		if item == nil {
+			complete = false
+			continue
+		}
+
+		result = append(result, item)
+		if fresh[i] {
+			discovered = append(discovered, item)
+		}
+	}
+
+	return result, discovered, complete
+}
+
+// prefetchRepos fetches metadata and go.mod of github deps not visited yet
+// with batched GraphQL queries. Returns nil if GraphQL is disabled or fails,
+// deps are fetched one by one with REST then.
+func prefetchRepos(deps []string, onGitHub map[string]bool) map[string]client.RepoInfo {
+	if !*useGraphQL {
+		return nil
+	}
+
+	keys := []string{}
+	for _, dep := range deps {
+		if _, visited := visitedItem(dep); onGitHub[dep] && !visited {
+			keys = append(keys, dep)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	var infos map[string]client.RepoInfo
+	var err error
+	limited(func() {
+		infos, err = gh.BatchRepos(keys)
+	})
+
+	if err != nil {
+		utils.HandleErrLog(err, "GRAPHQL BATCH")
+		return nil
+	}
+
+	return infos
+}
+
+// itemFromInfo creates item from metadata fetched with GraphQL
+func itemFromInfo(info client.RepoInfo) structs.Item {
+	item := structs.Item{
+		Name:            info.Name,
+		FullName:        info.FullName,
+		HTMLURL:         info.HTMLURL,
+		Description:     info.Description,
+		StargazersCount: info.StargazersCount,
+		ForksCount:      info.ForksCount,
+		Owner:           structs.Owner{AvatarURL: info.AvatarURL},
+	}
+	item.SetGoMod(info.GoMod)
+
+	return item
+}
+
+// resolveGitHubRepo returns `owner/repo` for module path hosted on github.
+// Vanity import paths (go.uber.org/zap) are resolved with go-import meta tags.
+func resolveGitHubRepo(path string) (string, bool) {
+	if dep, ok := gomod.GitHubRepo(path); ok {
+		return dep, true
+	}
+
+	imp, err := vanityResolver.Resolve(path)
+	if err != nil {
+		return "", false
+	}
+
+	return imp.GitHubRepo()
+}
+
+// createProxyItem creates item for module not hosted on github.
+// Returns an error if proxy does not know the module.
+func createProxyItem(path string) (structs.Item, error) {
+	var item structs.Item
+
+	var err error
+	limited(func() {
+		_, err = proxy.LatestVersion(path)
+	})
+	if err != nil {
+		return item, err
+	}
+
+	name := path[strings.LastIndex(path, "/")+1:]
+	if major := strings.LastIndex(path, "/v"); major > 0 && major == strings.LastIndex(path, "/") {
+		if _, err := utils.StrToInt(path[major+2:]); err == nil {
+			trimmed := path[:major]
+			name = trimmed[strings.LastIndex(trimmed, "/")+1:]
 		}
 	}
 
-	return result
+	item.Name = name
+	item.FullName = path
+	item.ModulePath = path
+	item.HTMLURL = "https://pkg.go.dev/" + path
+	item.SetReadme("")
+
+	return item, nil
 }
 
 // Gets repo from github. Returns Item struct or an error
@@ -216,14 +619,16 @@ func createItem(key string) (structs.Item, error) {
 	req, reqErr := gh.Request("GET", "/repos/"+key, "", nil)
 
 	if reqErr != nil {
-		utils.HandleErrPANIC(reqErr, "REQ ERR 2")
 		return item, reqErr
 	}
 
-	resp, respErr := gh.DoJson(req, &item)
+	var resp *http.Response
+	var respErr error
+	limited(func() {
+		resp, respErr = gh.DoJson(req, &item)
+	})
 
 	if respErr != nil {
-		utils.HandleErrPANIC(respErr, "RESP ERR 2")
 		return item, respErr
 	}
 
@@ -235,7 +640,11 @@ func createItem(key string) (structs.Item, error) {
 }
 
 func getReadmeHTML(key string) string {
-	readme, err := gh.GetHTML("/repos/" + key + "/readme")
+	var readme string
+	var err error
+	limited(func() {
+		readme, err = gh.GetHTML("/repos/" + key + "/readme")
+	})
 	if err != nil {
 		fmt.Println(err)
 		return ""
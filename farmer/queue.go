@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/a-sube/go-repos-api/structs"
+	"github.com/a-sube/go-repos-api/utils"
+	"github.com/go-redis/redis"
+)
+
+// Crawl state is kept in redis so a restarted farmer resumes where it stopped.
+//
+// rootKey is the root being crawled, its BFS frontier is stored in queueKey.
+// visitedPrefix followed by repo full name is a discovered item, it expires after visitedTTL.
+const (
+	rootKey       = "farmer:root"
+	queueKey      = "farmer:queue"
+	visitedPrefix = "farmer:visited:"
+)
+
+// currentRoot returns root which crawl was interrupted
+func currentRoot() (string, bool) {
+	key, err := redisClient.Get(rootKey).Result()
+	if err == redis.Nil {
+		return "", false
+	}
+	utils.HandleErrPANIC(err, "REDIS GET ROOT")
+
+	return key, true
+}
+
+// doneRoot removes BFS state of crawled root
+func doneRoot() {
+	_, err := redisClient.Del(rootKey, queueKey).Result()
+	utils.HandleErrPANIC(err, "REDIS DEL ROOT")
+}
+
+// resumedRoot reports whether BFS state of key is stored
+func resumedRoot(key string) bool {
+	current, err := redisClient.Get(rootKey).Result()
+	if err == redis.Nil {
+		return false
+	}
+	utils.HandleErrPANIC(err, "REDIS GET ROOT")
+
+	return current == key
+}
+
+// startRoot stores first BFS level of root key
+func startRoot(key string, modules []*structs.Item) {
+	_, err := redisClient.Del(queueKey).Result()
+	utils.HandleErrPANIC(err, "REDIS DEL QUEUE")
+
+	pushItems(modules)
+
+	_, err = redisClient.Set(rootKey, key, 0).Result()
+	utils.HandleErrPANIC(err, "REDIS SET ROOT")
+}
+
+func pushItems(items []*structs.Item) {
+	if len(items) == 0 {
+		return
+	}
+
+	values := make([]interface{}, len(items))
+	for i, item := range items {
+		data, err := json.Marshal(item)
+		utils.HandleErrPANIC(err, "QUEUE MARSHAL")
+		values[i] = data
+	}
+
+	_, err := redisClient.RPush(queueKey, values...).Result()
+	utils.HandleErrPANIC(err, "REDIS PUSH QUEUE")
+}
+
+// peekItems returns up to n items from the queue head. Items stay in queue
+// until popItems is called.
+func peekItems(n int) []*structs.Item {
+	values, err := redisClient.LRange(queueKey, 0, int64(n-1)).Result()
+	utils.HandleErrPANIC(err, "REDIS PEEK QUEUE")
+
+	items := make([]*structs.Item, len(values))
+	for i, value := range values {
+		var item structs.Item
+		utils.HandleErrPANIC(json.Unmarshal([]byte(value), &item), "QUEUE UNMARSHAL")
+		items[i] = &item
+	}
+
+	return items
+}
+
+// popItems removes n items from the queue head
+func popItems(n int) {
+	_, err := redisClient.LTrim(queueKey, int64(n), -1).Result()
+	utils.HandleErrPANIC(err, "REDIS POP QUEUE")
+}
+
+// visitedItem returns item discovered less than visitedTTL ago, without its modules
+func visitedItem(name string) (*structs.Item, bool) {
+	data, err := redisClient.Get(visitedPrefix + name).Bytes()
+	if err == redis.Nil {
+		return nil, false
+	}
+	utils.HandleErrPANIC(err, "REDIS GET VISITED")
+
+	var item structs.Item
+	utils.HandleErrPANIC(json.Unmarshal(data, &item), "VISITED UNMARSHAL")
+
+	return &item, true
+}
+
+// markVisited stores discovered item so it is not queued and its go.mod,
+// readme and metadata are not fetched again until visitedTTL passes
+func markVisited(item structs.Item) {
+	item.Modules = nil
+	item.GoMod = ""
+	item.GoModIsSet = false
+	item.ModulesComplete = false
+	item.Version = ""
+	item.Indirect = false
+	item.Replace = ""
+
+	data, err := json.Marshal(item)
+	utils.HandleErrPANIC(err, "VISITED MARSHAL")
+
+	_, err = redisClient.Set(visitedPrefix+item.FullName, data, *visitedTTL).Result()
+	utils.HandleErrPANIC(err, "REDIS SET VISITED")
+}
+
+// unmarkVisited removes visited marks of items, so items not stored are
+// discovered and fetched again instead of being skipped until visitedTTL
+func unmarkVisited(items []*structs.Item) {
+	if len(items) == 0 {
+		return
+	}
+
+	keys := make([]string, len(items))
+	for i, item := range items {
+		keys[i] = visitedPrefix + item.FullName
+	}
+
+	_, err := redisClient.Del(keys...).Result()
+	utils.HandleErrPANIC(err, "REDIS DEL VISITED")
+}
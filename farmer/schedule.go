@@ -0,0 +1,222 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a-sube/go-repos-api/structs"
+	"github.com/a-sube/go-repos-api/utils"
+	"github.com/go-redis/redis"
+)
+
+// Root repos are refreshed continuously. Each root has a due time in
+// scheduleKey sorted set, the most overdue root is crawled first. New roots
+// are due immediately, more starred ones first. A crawled root is due again
+// after refreshInterval, which is shorter for more starred repos.
+//
+// scheduleKey is a sorted set of root full names scored by unix due time.
+// crawledKey is a hash of repo full name to unix time it was last crawled.
+// nextDiscoveryKey is a unix time when roots are discovered next time.
+const (
+	scheduleKey      = "farmer:schedule"
+	crawledKey       = "farmer:last-crawled"
+	nextDiscoveryKey = "farmer:next-discovery"
+
+	// rootRetryDelay is a delay before root failed to be stored is crawled again
+	rootRetryDelay = time.Minute * 15
+)
+
+var (
+	refreshEvery = flag.Duration("refresh-interval", time.Hour*24*7, "refresh interval of repos without stars, more starred repos are refreshed more often")
+
+	refreshBudget = flag.Int("refresh-budget", 1000, "`number` of repos crawled per hour at most")
+
+	discoveryInterval = flag.Duration("discovery-interval", time.Hour*24, "roots are discovered every `duration`")
+
+	// budget is the earliest time next repo may be crawled
+	budget = struct {
+		sync.Mutex
+		next time.Time
+	}{}
+)
+
+// schedule discovers roots every -discovery-interval and crawls due roots
+// until stopped. Interrupted root is crawled first.
+func schedule() {
+
+	if key, ok := currentRoot(); ok {
+		log.Printf("RESUMING INTERRUPTED ROOT %s\n", key)
+		crawlRoot(key)
+	}
+
+	for !stopped() {
+		if !time.Now().Before(nextDiscovery()) {
+			discoverRepos()
+			if stopped() {
+				return
+			}
+
+			scheduleRoots()
+			setNextDiscovery(time.Now().Add(*discoveryInterval))
+
+			log.Printf("DISCOVERY DONE! REQUESTS MADE: %d CACHE HITS: %d\n", gh.RequestsMade(), gh.CacheHits())
+			gh.LogRequest()
+			gh.Reset()
+		}
+
+		key, due, ok := nextDue()
+		if !ok || due.After(time.Now()) {
+			wake := nextDiscovery()
+			if ok && due.Before(wake) {
+				wake = due
+			}
+
+			if !sleep(time.Until(wake)) {
+				return
+			}
+			continue
+		}
+
+		crawlRoot(key)
+	}
+}
+
+// crawlRoot crawls root key and schedules its next refresh. Interrupted
+// root is not rescheduled, its BFS state is kept to resume it. Root which
+// failed to be stored is retried after rootRetryDelay.
+func crawlRoot(key string) {
+	ok := runBFSlike(key)
+
+	if stopped() {
+		return
+	}
+
+	if !ok {
+		log.Printf("ROOT %s SKIPPED, RETRYING IN %s\n", key, rootRetryDelay)
+		setDue(key, time.Now().Add(rootRetryDelay))
+		return
+	}
+
+	doneRoot()
+
+	item, _ := getItemFromRedis(key)
+	setDue(key, time.Now().Add(refreshInterval(item.StargazersCount)))
+}
+
+// refreshInterval returns how long crawled repo with stars stays fresh
+func refreshInterval(stars int) time.Duration {
+	return time.Duration(float64(*refreshEvery) / (1 + math.Log10(1+float64(stars))))
+}
+
+// spendBudget waits until one more repo can be crawled within -refresh-budget.
+// Returns false if stopped while waiting.
+func spendBudget() bool {
+	budget.Lock()
+	now := time.Now()
+	if budget.next.Before(now) {
+		budget.next = now
+	}
+	wait := budget.next.Sub(now)
+	budget.next = budget.next.Add(time.Hour / time.Duration(*refreshBudget))
+	budget.Unlock()
+
+	return sleep(wait)
+}
+
+// scheduleRoots adds discovered roots not scheduled yet, due immediately.
+// Negative stars count is used as due time so more starred roots go first.
+func scheduleRoots() {
+	roots, err := redisClient.HKeys("go-api").Result()
+	utils.HandleErrPANIC(err, "REDIS GET ROOTS")
+
+	members := []redis.Z{}
+	for _, key := range roots {
+		item, err := getItemFromRedis(key)
+		if err != nil {
+			continue
+		}
+		members = append(members, redis.Z{Score: float64(-item.StargazersCount), Member: key})
+	}
+
+	if len(members) == 0 {
+		return
+	}
+
+	_, err = redisClient.ZAddNX(scheduleKey, members...).Result()
+	utils.HandleErrPANIC(err, "REDIS SCHEDULE ROOTS")
+}
+
+// nextDue returns the most overdue root
+func nextDue() (string, time.Time, bool) {
+	members, err := redisClient.ZRangeWithScores(scheduleKey, 0, 0).Result()
+	utils.HandleErrPANIC(err, "REDIS NEXT DUE")
+
+	if len(members) == 0 {
+		return "", time.Time{}, false
+	}
+
+	key, _ := members[0].Member.(string)
+	return key, time.Unix(int64(members[0].Score), 0), true
+}
+
+func setDue(key string, due time.Time) {
+	_, err := redisClient.ZAdd(scheduleKey, redis.Z{Score: float64(due.Unix()), Member: key}).Result()
+	utils.HandleErrPANIC(err, "REDIS SET DUE")
+}
+
+// markCrawled stores last crawl time of repo
+func markCrawled(key string) {
+	_, err := redisClient.HSet(crawledKey, strings.ToLower(key), time.Now().Unix()).Result()
+	utils.HandleErrPANIC(err, "REDIS SET CRAWLED")
+}
+
+// lastCrawled returns last crawl time of repo
+func lastCrawled(key string) (time.Time, bool) {
+	value, err := redisClient.HGet(crawledKey, strings.ToLower(key)).Result()
+	if err == redis.Nil {
+		return time.Time{}, false
+	}
+	utils.HandleErrPANIC(err, "REDIS GET CRAWLED")
+
+	unix, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(unix, 0), true
+}
+
+// staleItems returns discovered dependencies due to be crawled: never
+// crawled or crawled longer than their refresh interval ago. Fresh ones are
+// stored as modules of their dependents but their go.mod is not fetched
+// again, so they don't spend -refresh-budget.
+func staleItems(items []*structs.Item) []*structs.Item {
+	stale := []*structs.Item{}
+	for _, item := range items {
+		last, ok := lastCrawled(item.FullName)
+		if !ok || time.Since(last) >= refreshInterval(item.StargazersCount) {
+			stale = append(stale, item)
+		}
+	}
+	return stale
+}
+
+// nextDiscovery returns time of next discovery, zero if never discovered
+func nextDiscovery() time.Time {
+	unix, err := redisClient.Get(nextDiscoveryKey).Int64()
+	if err == redis.Nil {
+		return time.Time{}
+	}
+	utils.HandleErrPANIC(err, "REDIS GET NEXT DISCOVERY")
+
+	return time.Unix(unix, 0)
+}
+
+func setNextDiscovery(t time.Time) {
+	_, err := redisClient.Set(nextDiscoveryKey, t.Unix(), 0).Result()
+	utils.HandleErrPANIC(err, "REDIS SET NEXT DISCOVERY")
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/a-sube/go-repos-api/structs"
+	"github.com/a-sube/go-repos-api/utils"
+)
+
+// Seed is a named set of root repos. Exactly one source is set:
+// a search query, a topic, an organization, a file listing `owner/repo`
+// per line or a user whose starred repos are used.
+type Seed struct {
+	Name      string `json:"name"`
+	Query     string `json:"query,omitempty"`
+	Topic     string `json:"topic,omitempty"`
+	Org       string `json:"org,omitempty"`
+	ReposFile string `json:"repos_file,omitempty"`
+	UserStars string `json:"user_stars,omitempty"`
+	// Max is the count of repos taken from the seed, 0 is not limited.
+	// Search based seeds are limited to 1000 repos by GitHub.
+	Max int `json:"max,omitempty"`
+}
+
+// SeedsConfig is a seeds config file
+type SeedsConfig struct {
+	Seeds []Seed `json:"seeds"`
+}
+
+var (
+	seedsFile = flag.String("seeds", "", "JSON config `file` with seed sets of root repos, default is the 1000 most starred Go repos")
+
+	// defaultSeeds are used in top discovery mode when no config is given
+	defaultSeeds = []Seed{{Name: "popular", Query: "go package in:readme language:go", Max: searchCap}}
+
+	seeds []Seed
+)
+
+// loadSeeds reads seeds config. Relative repo list files are resolved
+// against directory of the config.
+func loadSeeds(file string) ([]Seed, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var config SeedsConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%v: %v", file, err)
+	}
+
+	for i := range config.Seeds {
+		seed := &config.Seeds[i]
+
+		sources := 0
+		for _, source := range []string{seed.Query, seed.Topic, seed.Org, seed.ReposFile, seed.UserStars} {
+			if source != "" {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return nil, fmt.Errorf("%v: seed %q must have exactly one of query, topic, org, repos_file, user_stars", file, seed.Name)
+		}
+
+		if seed.Name == "" {
+			seed.Name = "seed " + utils.IntToStr(i+1)
+		}
+
+		if seed.ReposFile != "" && !filepath.IsAbs(seed.ReposFile) {
+			seed.ReposFile = filepath.Join(filepath.Dir(file), seed.ReposFile)
+		}
+	}
+
+	return config.Seeds, nil
+}
+
+// discoverSeeds stores repos of all seeds to redis
+func discoverSeeds() {
+	for _, seed := range seeds {
+		if stopped() {
+			return
+		}
+
+		log.Printf("SEED %q\n", seed.Name)
+
+		switch {
+		case seed.Query != "":
+			sendSearchRequests(seed.Query, seed.Max)
+		case seed.Topic != "":
+			sendSearchRequests("topic:"+seed.Topic+" language:go", seed.Max)
+		case seed.Org != "":
+			listRepos("/orgs/"+seed.Org+"/repos", seed.Max)
+		case seed.UserStars != "":
+			listRepos("/users/"+seed.UserStars+"/starred", seed.Max)
+		case seed.ReposFile != "":
+			fetchListedRepos(seed.ReposFile, seed.Max)
+		}
+	}
+}
+
+// listedRepo is a repo returned by listing endpoints
+type listedRepo struct {
+	structs.Item
+	Language string `json:"language"`
+}
+
+// listRepos stores Go repos of a listing endpoint page by page, up to max
+// repos if max is not 0. Organization listing includes private repos the
+// token has access to.
+func listRepos(path string, max int) {
+	stored := 0
+
+	for page := 1; !stopped(); page++ {
+		req, err := gh.Request("GET", path, "per_page=100&page="+utils.IntToStr(page), nil)
+		if err != nil {
+			utils.HandleErrLog(err, "LIST REQ ERR")
+			return
+		}
+
+		var repos []listedRepo
+		resp, err := gh.DoJson(req, &repos)
+		if err != nil {
+			utils.HandleErrLog(err, "LIST RESP ERR "+path+", PAGE "+utils.IntToStr(page))
+			return
+		}
+		if resp.StatusCode != 200 {
+			log.Printf("LIST RESP ERR %v: %v\n", path, resp.Status)
+			return
+		}
+
+		for _, repo := range repos {
+			if repo.Language != "Go" {
+				continue
+			}
+			if max > 0 && stored >= max {
+				return
+			}
+
+			storeRoot(repo.Item)
+			stored++
+		}
+
+		if len(repos) < 100 {
+			return
+		}
+	}
+}
+
+// fetchListedRepos stores repos listed in file, up to max if max is not 0.
+// Empty lines and lines starting with `#` are skipped.
+func fetchListedRepos(file string, max int) {
+	f, err := os.Open(file)
+	if err != nil {
+		utils.HandleErrLog(err, "REPOS FILE")
+		return
+	}
+	defer f.Close()
+
+	stored := 0
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() && !stopped() {
+		key := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		key = strings.TrimPrefix(key, "https://github.com/")
+		key = strings.TrimPrefix(key, "github.com/")
+
+		if key == "" || strings.HasPrefix(key, "#") {
+			continue
+		}
+		if max > 0 && stored >= max {
+			return
+		}
+
+		item, err := createItem(key)
+		if err != nil {
+			utils.HandleErrLog(err, "LISTED REPO "+key)
+			continue
+		}
+
+		storeRoot(item)
+		stored++
+	}
+
+	utils.HandleErrLog(scanner.Err(), "REPOS FILE")
+}
+
+// storeRoot stores item to redis as a root, it is scheduled after discovery
+func storeRoot(item structs.Item) {
+	data, err := json.Marshal(item)
+	utils.HandleErrPANIC(err, "ROOT MARSHAL")
+
+	_, err = redisClient.HSet("go-api", strings.ToLower(item.FullName), data).Result()
+	utils.HandleErrPANIC(err, "REDIS SET ROOT")
+}
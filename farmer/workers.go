@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sync"
+
+	client "github.com/a-sube/go-repos-api/gh-client"
+	"github.com/a-sube/go-repos-api/gomod"
+	"github.com/a-sube/go-repos-api/structs"
+)
+
+var (
+	// slots bounds count of concurrent GitHub and proxy requests to -workers
+	slots chan struct{}
+
+	// fetching holds dependencies being fetched, so concurrent workers
+	// discovering the same dependency wait for a single fetch
+	fetching = struct {
+		sync.Mutex
+		deps map[string]*sync.WaitGroup
+	}{deps: make(map[string]*sync.WaitGroup)}
+)
+
+// limited runs fn holding one of the worker slots
+func limited(fn func()) {
+	slots <- struct{}{}
+	defer func() { <-slots }()
+
+	fn()
+}
+
+// fetchDep returns item of dependency dep. Item not visited before is fetched,
+// marked visited and reported as discovered. Metadata prefetched with GraphQL
+// is used if info is not nil.
+func fetchDep(dep string, req gomod.Require, onGitHub bool, info *client.RepoInfo) (item structs.Item, discovered bool, err error) {
+	for {
+		if visited, ok := visitedItem(dep); ok {
+			return *visited, false, nil
+		}
+
+		fetching.Lock()
+		wait, ok := fetching.deps[dep]
+		if !ok {
+			wait = &sync.WaitGroup{}
+			wait.Add(1)
+			fetching.deps[dep] = wait
+		}
+		fetching.Unlock()
+
+		if !ok {
+			break
+		}
+
+		// other worker is fetching dep, check visited again when it is done
+		wait.Wait()
+	}
+
+	defer func() {
+		fetching.Lock()
+		fetching.deps[dep].Done()
+		delete(fetching.deps, dep)
+		fetching.Unlock()
+	}()
+
+	// dep could be visited between the check and the claim
+	if visited, ok := visitedItem(dep); ok {
+		return *visited, false, nil
+	}
+
+	if onGitHub {
+		if info != nil {
+			item = itemFromInfo(*info)
+		} else {
+			item, err = createItem(dep)
+			if err != nil {
+				return item, false, err
+			}
+		}
+		item.SetReadme(getReadmeHTML(dep))
+	} else {
+		item, err = createProxyItem(req.Path)
+		if err != nil {
+			return item, false, err
+		}
+	}
+
+	item.Normalize()
+	markVisited(item)
+
+	return item, true, nil
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"net/http"
+	"sync"
+)
+
+// CachedResponse is a response body with its validators
+type CachedResponse struct {
+	ETag         string `json:"etag"`
+	LastModified string `json:"last_modified"`
+	Body         []byte `json:"body"`
+}
+
+// Cache stores responses for conditional requests. GitHub does not count
+// `304 Not Modified` responses against the rate limit.
+type Cache interface {
+	Get(key string) (CachedResponse, bool)
+	Set(key string, resp CachedResponse)
+}
+
+// MemoryCache is an in-memory Cache
+type MemoryCache struct {
+	mu        sync.Mutex
+	responses map[string]CachedResponse
+}
+
+// NewMemoryCache returns an empty MemoryCache
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{responses: make(map[string]CachedResponse)}
+}
+
+// Get returns cached response for key
+func (c *MemoryCache) Get(key string) (CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resp, ok := c.responses[key]
+	return resp, ok
+}
+
+// Set stores response for key
+func (c *MemoryCache) Set(key string, resp CachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.responses[key] = resp
+}
+
+// cacheKey is a request method, url and Accept header. Different media
+// types of the same url (raw, html) are cached separately.
+func cacheKey(req *http.Request) string {
+	return req.Method + " " + req.URL.String() + " " + req.Header.Get("Accept")
+}
+
+// SetCache enables conditional requests with ETag and Last-Modified
+// validators stored in cache.
+func (gh *GitHubClient) SetCache(cache Cache) {
+	gh.cache = cache
+}
+
+// CacheHits returns count of `304 Not Modified` responses served from cache
+func (gh *GitHubClient) CacheHits() int {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+
+	return gh.cacheHits
+}
+
+// setConditional sets If-None-Match and If-Modified-Since headers of GET
+// request if its response is cached.
+func (gh *GitHubClient) setConditional(req *http.Request) (CachedResponse, bool) {
+	if gh.cache == nil || req.Method != "GET" {
+		return CachedResponse{}, false
+	}
+
+	cached, ok := gh.cache.Get(cacheKey(req))
+	if !ok {
+		return cached, false
+	}
+
+	if cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	if cached.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.LastModified)
+	}
+
+	return cached, true
+}
+
+// fromCache returns cached body for `304 Not Modified` response and turns
+// it into `200 OK`. Successful responses with validators are stored.
+func (gh *GitHubClient) fromCache(req *http.Request, resp *http.Response, body []byte, cached CachedResponse, isCached bool) ([]byte, bool) {
+	if gh.cache == nil || req.Method != "GET" {
+		return body, false
+	}
+
+	if resp.StatusCode == http.StatusNotModified && isCached {
+		resp.StatusCode = http.StatusOK
+		resp.Status = "200 OK"
+		resp.Header.Set("X-From-Cache", "1")
+		gh.mu.Lock()
+		gh.cacheHits++
+		gh.mu.Unlock()
+		return cached.Body, true
+	}
+
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+
+	if resp.StatusCode == http.StatusOK && (etag != "" || lastModified != "") {
+		gh.cache.Set(cacheKey(req), CachedResponse{
+			ETag:         etag,
+			LastModified: lastModified,
+			Body:         body,
+		})
+	}
+
+	return body, false
+}
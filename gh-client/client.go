@@ -7,60 +7,144 @@ import (
 
 	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/a-sube/go-repos-api/utils"
 )
 
-var GH = GitHubClient{
-	ghClient: &http.Client{},
-	ghURL: &url.URL{
-		Scheme: "https",
-		Host:   "api.github.com",
-	},
-	requests: -9, // do not cont first 10 initial requests
-}
+// DefaultURL is the GitHub REST API base URL
+const DefaultURL = "https://api.github.com"
+
+// GH is a default client talking to api.github.com with tokens from GITHUB_ACCESS_TOKEN
+var GH, _ = NewGitHubClientWithTokens(DefaultURL, utils.AccessTokens(), nil)
 
-// GitHubClient is a github http client
+// GitHubClient is a github http client. It is safe for concurrent use.
 type GitHubClient struct {
-	ghClient  *http.Client
-	ghURL     *url.URL // string // "https://api.github.com/"
-	limit     int
-	requests  int
-	resetTime int64
-	initial   bool
+	ghClient *http.Client
+	ghURL    *url.URL // string // "https://api.github.com/"
+	gqlURL   *url.URL // "https://api.github.com/graphql"
+	cache    Cache
+
+	// now and sleep are replaced in tests
+	now   func() time.Time
+	sleep func(time.Duration)
+
+	// mu guards counters below and budgets of tokens
+	mu              sync.Mutex
+	tokens          []*tokenState
+	cacheHits       int
+	requests        int
+	searchRequests  int
+	graphqlRequests int
+}
+
+// NewGitHubClient returns a client sending requests to baseURL with token.
+// If transport is nil http.DefaultTransport is used.
+func NewGitHubClient(baseURL, token string, transport http.RoundTripper) (*GitHubClient, error) {
+	return NewGitHubClientWithTokens(baseURL, []string{token}, transport)
+}
+
+// NewGitHubClientWithTokens returns a client sending requests to baseURL.
+// Each request is sent with the token having the most remaining budget.
+// With no tokens requests are sent unauthenticated.
+func NewGitHubClientWithTokens(baseURL string, tokens []string, transport http.RoundTripper) (*GitHubClient, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("Invalid GitHub base URL: %v", baseURL)
+	}
+
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	states := []*tokenState{}
+	for _, token := range tokens {
+		if token != "" {
+			states = append(states, newTokenState(token))
+		}
+	}
+	if len(states) == 0 {
+		states = append(states, newTokenState(""))
+	}
+
+	return &GitHubClient{
+		ghClient: &http.Client{Transport: transport},
+		ghURL:    u,
+		gqlURL:   graphqlURL(u),
+		now:      time.Now,
+		sleep:    time.Sleep,
+		tokens:   states,
+	}, nil
 }
 
-func (gh *GitHubClient) checkLimit() {
-	if gh.limit <= 2 {
-		timeLeft := gh.resetTime - time.Now().Unix()
-		time.Sleep(time.Second * time.Duration(timeLeft))
+// graphqlURL returns GraphQL API URL of REST API base URL. GitHub Enterprise
+// serves REST API under /api/v3 and GraphQL API at /api/graphql, while
+// api.github.com serves GraphQL API at /graphql.
+func graphqlURL(base *url.URL) *url.URL {
+	u := *base
+	path := strings.TrimSuffix(base.Path, "/")
+
+	if strings.HasSuffix(path, "/api/v3") {
+		u.Path = strings.TrimSuffix(path, "/v3") + "/graphql"
+	} else {
+		u.Path = path + "/graphql"
 	}
+	u.RawPath = ""
+
+	return &u
 }
 
-func (gh *GitHubClient) setLimit(xRemaining, xTimeReset string) {
-	limit, _ := utils.StrToInt(xRemaining)
-	reset, _ := utils.StrToInt(xTimeReset)
-	gh.limit = limit
-	gh.resetTime = int64(reset)
+// StatusError is returned for a response with non-2xx status which is not
+// retried or still fails when retries are exhausted.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
 }
 
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("GitHub %v: %v", e.Status, e.Body)
+}
+
+// IsNotFound reports whether err is a `404 Not Found` response
+func IsNotFound(err error) bool {
+	statusErr, ok := err.(*StatusError)
+	return ok && statusErr.StatusCode == http.StatusNotFound
+}
+
+func newStatusError(resp *http.Response, body []byte) *StatusError {
+	return &StatusError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       strings.TrimSpace(string(body)),
+	}
+}
+
+// RequestsMade returns count of requests made against the core rate limit,
+// search requests are not counted.
 func (gh *GitHubClient) RequestsMade() int {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+
 	return gh.requests
 }
 
 func (gh *GitHubClient) Request(method, path, query string, body interface{}) (*http.Request, error) {
 
-	rel := &url.URL{Path: path}
+	rel := &url.URL{Path: strings.TrimSuffix(gh.ghURL.Path, "/") + path}
 	url := gh.ghURL.ResolveReference(rel)
 
 	if query != "" {
 		url.RawQuery = query
-		gh.initial = true
-	} else {
-		gh.initial = false
 	}
 
 	var buf io.ReadWriter
@@ -78,67 +162,119 @@ func (gh *GitHubClient) Request(method, path, query string, body interface{}) (*
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", "token "+utils.ACCESSTOKEN)
-
 	return req, nil
 }
 
+// DoJson sends request and decodes json response body to v.
+// Cached body is decoded for `304 Not Modified` response.
+// Non-2xx response is returned with *StatusError.
 func (gh *GitHubClient) DoJson(req *http.Request, v interface{}) (*http.Response, error) {
 
-	gh.checkLimit()
+	resp, body, err := gh.do(req)
+	if err != nil {
+		return resp, err
+	}
 
-	resp, respErr := gh.ghClient.Do(req)
-	if respErr != nil {
-		return nil, respErr
+	jsonErr := json.Unmarshal(body, v)
+
+	return resp, jsonErr
+}
+
+// DoRaw sends request and returns response body as a string.
+// Cached body is returned for `304 Not Modified` response.
+// Non-2xx response returns *StatusError, its body is not returned.
+func (gh *GitHubClient) DoRaw(req *http.Request, v interface{}) (string, error) {
+
+	_, body, err := gh.do(req)
+	if err != nil {
+		return "", err
 	}
 
-	defer resp.Body.Close()
-	jsonErr := json.NewDecoder(resp.Body).Decode(v)
-
-	// in case we are not doing initial 10 requests
-	if !gh.initial {
-		if len(resp.Header["X-Ratelimit-Remaining"]) > 0 &&
-			len(resp.Header["X-Ratelimit-Reset"]) > 0 {
-			gh.setLimit(
-				resp.Header["X-Ratelimit-Remaining"][0],
-				resp.Header["X-Ratelimit-Reset"][0],
-			)
+	return string(body), nil
+}
+
+// do sends request, retrying rate limited and failed requests.
+// Returns an error when retries are exhausted and *StatusError for
+// non-2xx response which is not retried.
+func (gh *GitHubClient) do(req *http.Request) (*http.Response, []byte, error) {
+
+	for attempt := 0; ; attempt++ {
+		resp, body, err := gh.send(req)
+
+		delay, retry := retryDelay(resp, body, attempt)
+		if err == nil && !retry {
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				return resp, body, newStatusError(resp, body)
+			}
+			return resp, body, nil
+		}
+
+		if attempt == maxRetries {
+			if err == nil {
+				err = newStatusError(resp, body)
+			}
+			return resp, body, err
+		}
+
+		log.Printf("RETRYING %v %v IN %v (attempt %d): status %v err %v", req.Method, req.URL.Path, delay, attempt+1, statusOf(resp), err)
+		gh.sleep(delay)
+
+		if rewindErr := rewind(req); rewindErr != nil {
+			return resp, body, rewindErr
 		}
-		// gh.LogRequest()
 	}
-	gh.requests++
-	return resp, jsonErr
 }
 
-func (gh *GitHubClient) DoRaw(req *http.Request, v interface{}) (string, error) {
+// send sends request once with the token having the most budget
+func (gh *GitHubClient) send(req *http.Request) (*http.Response, []byte, error) {
 
-	gh.checkLimit()
+	name := gh.bucketOf(req)
+
+	token := gh.pickToken(name)
+	if token.value != "" {
+		req.Header.Set("Authorization", "token "+token.value)
+	}
+
+	cached, isCached := gh.setConditional(req)
 
 	resp, respErr := gh.ghClient.Do(req)
 	if respErr != nil {
-		return "", respErr
+		return nil, nil, respErr
 	}
 
 	defer resp.Body.Close()
 
 	body, bodyErr := ioutil.ReadAll(resp.Body)
 	if bodyErr != nil {
-		return "", bodyErr
+		return resp, nil, bodyErr
 	}
 
-	if !gh.initial {
-		if len(resp.Header["X-Ratelimit-Remaining"]) > 0 &&
-			len(resp.Header["X-Ratelimit-Reset"]) > 0 {
-			gh.setLimit(
-				resp.Header["X-Ratelimit-Remaining"][0],
-				resp.Header["X-Ratelimit-Reset"][0],
-			)
+	gh.setLimit(token, name, resp.Header)
+
+	body, hit := gh.fromCache(req, resp, body, cached, isCached)
+
+	// 304 responses are not counted against the rate limit
+	if !hit {
+		gh.mu.Lock()
+		switch name {
+		case searchBucket:
+			gh.searchRequests++
+		case graphqlBucket:
+			gh.graphqlRequests++
+		default:
+			gh.requests++
 		}
-		// gh.LogRequest()
+		gh.mu.Unlock()
 	}
-	
-	gh.requests++
-	return string(body), nil
+
+	return resp, body, nil
+}
+
+func statusOf(resp *http.Response) string {
+	if resp == nil {
+		return "none"
+	}
+	return resp.Status
 }
 
 func (gh *GitHubClient) GetRawContent(path string) (string, error) {
@@ -166,10 +302,27 @@ func (gh *GitHubClient) GetHTML(path string) (string, error) {
 }
 
 func (gh *GitHubClient) LogRequest() {
-	timeLeft := gh.resetTime - time.Now().Unix()
-	fmt.Printf("requests: %v\tlimit: %v\treset: %v\ttime before reset: %v\n", gh.requests, gh.limit, gh.resetTime, timeLeft)
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+
+	fmt.Printf("requests: %v\tsearch requests: %v\tgraphql requests: %v\tcache hits: %v\ttokens: %v\n", gh.requests, gh.searchRequests, gh.graphqlRequests, gh.cacheHits, len(gh.tokens))
+
+	// tokens are printed by index, never by value
+	for i, t := range gh.tokens {
+		fmt.Printf("token #%d\trequests: %v\n", i, t.requests)
+		for _, name := range []string{coreBucket, searchBucket, graphqlBucket} {
+			b := t.buckets[name]
+			timeLeft := b.resetTime - time.Now().Unix()
+			fmt.Printf("token #%d %v\tlimit: %v\treset: %v\ttime before reset: %v\n", i, name, b.limit, b.resetTime, timeLeft)
+		}
+	}
 }
 
 func (gh *GitHubClient) Reset() {
-	gh.requests = -9
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+
+	gh.requests = 0
+	gh.searchRequests = 0
+	gh.graphqlRequests = 0
 }
@@ -0,0 +1,180 @@
+package client
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/a-sube/go-repos-api/gh-client/fakegh"
+	"github.com/a-sube/go-repos-api/structs"
+)
+
+const testGoMod = "module github.com/gorilla/mux\n\ngo 1.12\n"
+
+// newTestClient returns a client of a fake server serving gorilla/mux
+func newTestClient(t *testing.T) (*GitHubClient, *fakegh.Server) {
+	t.Helper()
+
+	server := fakegh.NewServer()
+	server.AddRepo(structs.Item{FullName: "gorilla/mux", StargazersCount: 100}, testGoMod, "<h1>mux</h1>")
+
+	gh, err := NewGitHubClient(server.URL, "token", nil)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+
+	return gh, server
+}
+
+func TestDoJson(t *testing.T) {
+	gh, server := newTestClient(t)
+	defer server.Close()
+
+	req, err := gh.Request("GET", "/repos/gorilla/mux", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var item structs.Item
+	resp, err := gh.DoJson(req, &item)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status %d, want 200", resp.StatusCode)
+	}
+	if item.FullName != "gorilla/mux" || item.StargazersCount != 100 {
+		t.Errorf("item %+v, want gorilla/mux with 100 stars", item)
+	}
+	if gh.RequestsMade() != 1 {
+		t.Errorf("%d requests made, want 1", gh.RequestsMade())
+	}
+}
+
+func TestDoRaw(t *testing.T) {
+	gh, server := newTestClient(t)
+	defer server.Close()
+
+	gomod, err := gh.GetRawContent("/repos/gorilla/mux/contents/go.mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gomod != testGoMod {
+		t.Errorf("go.mod %q, want %q", gomod, testGoMod)
+	}
+}
+
+func TestNotModifiedFromCache(t *testing.T) {
+	gh, server := newTestClient(t)
+	defer server.Close()
+
+	gh.SetCache(NewMemoryCache())
+
+	for i := 0; i < 2; i++ {
+		gomod, err := gh.GetRawContent("/repos/gorilla/mux/contents/go.mod")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gomod != testGoMod {
+			t.Errorf("request %d: go.mod %q, want %q", i, gomod, testGoMod)
+		}
+	}
+
+	if server.Requests() != 2 {
+		t.Errorf("%d requests served, want 2", server.Requests())
+	}
+	if gh.CacheHits() != 1 {
+		t.Errorf("%d cache hits, want 1", gh.CacheHits())
+	}
+	if gh.RequestsMade() != 1 {
+		t.Errorf("%d requests made, want 1, 304 is not counted", gh.RequestsMade())
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	gh, server := newTestClient(t)
+	defer server.Close()
+
+	reset := time.Now().Add(time.Minute * 30).Truncate(time.Second)
+	server.SetRateLimit(42, reset)
+
+	if _, err := gh.GetRawContent("/repos/gorilla/mux/contents/go.mod"); err != nil {
+		t.Fatal(err)
+	}
+
+	b := gh.tokens[0].buckets[coreBucket]
+	if !b.known || b.limit != 41 || b.resetTime != reset.Unix() {
+		t.Errorf("core bucket %+v, want limit 41 and reset %v", *b, reset.Unix())
+	}
+
+	if gh.tokens[0].buckets[searchBucket].known {
+		t.Errorf("search bucket is known after core request")
+	}
+}
+
+func TestNonOKStatus(t *testing.T) {
+	gh, server := newTestClient(t)
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		fail   int
+		path   string
+		status int
+	}{
+		{"missing repo", 0, "/repos/gorilla/nope", http.StatusNotFound},
+		{"missing go.mod", 0, "/repos/gorilla/mux/contents/nope", http.StatusNotFound},
+		{"bad credentials", http.StatusUnauthorized, "/repos/gorilla/mux", http.StatusUnauthorized},
+		{"unavailable for legal reasons", http.StatusUnavailableForLegalReasons, "/repos/gorilla/mux", http.StatusUnavailableForLegalReasons},
+	}
+
+	for _, test := range tests {
+		if test.fail != 0 {
+			server.FailNext(1, test.fail, "")
+		}
+		before := server.Requests()
+
+		body, err := gh.GetRawContent(test.path)
+
+		statusErr, ok := err.(*StatusError)
+		if !ok {
+			t.Errorf("%s: error %v, want *StatusError", test.name, err)
+			continue
+		}
+		if statusErr.StatusCode != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, statusErr.StatusCode, test.status)
+		}
+		if body != "" {
+			t.Errorf("%s: body %q returned with error", test.name, body)
+		}
+		if IsNotFound(err) != (test.status == http.StatusNotFound) {
+			t.Errorf("%s: IsNotFound is %v", test.name, IsNotFound(err))
+		}
+		if n := server.Requests() - before; n != 1 {
+			t.Errorf("%s: %d requests sent, want 1, status is not retried", test.name, n)
+		}
+	}
+}
+
+func TestServerErrorRetried(t *testing.T) {
+	gh, server := newTestClient(t)
+	defer server.Close()
+
+	newFakeClock(gh)
+	server.FailNext(1, http.StatusInternalServerError, "")
+
+	gomod, err := gh.GetRawContent("/repos/gorilla/mux/contents/go.mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gomod != testGoMod {
+		t.Errorf("go.mod %q, want %q", gomod, testGoMod)
+	}
+	if server.Requests() != 2 {
+		t.Errorf("%d requests served, want 2", server.Requests())
+	}
+}
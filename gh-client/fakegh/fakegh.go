@@ -0,0 +1,451 @@
+// Package fakegh is an in-process fake of the GitHub REST API endpoints used by
+// the farmer. It serves repositories added with AddRepo or loaded from a
+// fixtures directory and sends rate limit headers with every response.
+package fakegh
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a-sube/go-repos-api/structs"
+)
+
+// DefaultLimit is the rate limit a new server starts with
+const DefaultLimit = 5000
+
+// searchCap is the count of search results GitHub serves at most
+const searchCap = 1000
+
+// Repo is a single fake repository
+type Repo struct {
+	Item          structs.Item
+	GoMod         string
+	Readme        string
+	DefaultBranch string
+}
+
+// Server is a fake GitHub API server
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	repos     map[string]*Repo
+	limit     int
+	remaining int
+	reset     time.Time
+	requests  int
+	failures  []failure
+
+	// tokens are rate limits of tokens set with SetTokenRateLimit,
+	// requests of other tokens count against the server limit
+	tokens map[string]*tokenLimit
+}
+
+// tokenLimit is a rate limit of a single token
+type tokenLimit struct {
+	remaining int
+	reset     time.Time
+	requests  int
+}
+
+// failure is a response served instead of the next request
+type failure struct {
+	status     int
+	retryAfter string
+	message    string
+}
+
+// NewServer starts and returns a new fake GitHub server.
+// Caller should call Close when finished.
+func NewServer() *Server {
+	s := &Server{
+		repos:     make(map[string]*Repo),
+		tokens:    make(map[string]*tokenLimit),
+		limit:     DefaultLimit,
+		remaining: DefaultLimit,
+		reset:     time.Now().Add(time.Hour),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// AddRepo adds repository to the server. Key is taken from item.FullName.
+func (s *Server) AddRepo(item structs.Item, gomod, readme string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.ToLower(item.FullName)
+	if item.Name == "" {
+		item.Name = key[strings.Index(key, "/")+1:]
+	}
+	if item.HTMLURL == "" {
+		item.HTMLURL = "https://github.com/" + item.FullName
+	}
+
+	s.repos[key] = &Repo{Item: item, GoMod: gomod, Readme: readme, DefaultBranch: "master"}
+}
+
+// SetDefaultBranch sets default branch of repository added before
+func (s *Server) SetDefaultBranch(fullName, branch string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if repo, ok := s.repos[strings.ToLower(fullName)]; ok {
+		repo.DefaultBranch = branch
+	}
+}
+
+// LoadDir adds all repositories found in dir. Layout of the directory is
+// `<owner>/<repo>/repo.json` with optional `go.mod` and `README.html` files
+// next to it.
+func (s *Server) LoadDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*", "repo.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		var item structs.Item
+		if err := json.Unmarshal(data, &item); err != nil {
+			return fmt.Errorf("%v: %v", file, err)
+		}
+
+		repoDir := filepath.Dir(file)
+		if item.FullName == "" {
+			item.FullName = filepath.Base(filepath.Dir(repoDir)) + "/" + filepath.Base(repoDir)
+		}
+
+		gomod, _ := readOptional(filepath.Join(repoDir, "go.mod"))
+		readme, _ := readOptional(filepath.Join(repoDir, "README.html"))
+
+		s.AddRepo(item, gomod, readme)
+	}
+
+	return nil
+}
+
+// SetRateLimit sets remaining requests count and reset time
+func (s *Server) SetRateLimit(remaining int, reset time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remaining = remaining
+	s.reset = reset
+}
+
+// SetTokenRateLimit sets remaining requests count and reset time of token.
+// Requests of token with no remaining requests fail with `403 Forbidden`
+// and a rate limit exceeded message, same as GitHub does.
+func (s *Server) SetTokenRateLimit(token string, remaining int, reset time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token] = &tokenLimit{remaining: remaining, reset: reset}
+}
+
+// TokenRequests returns count of requests sent with token set with
+// SetTokenRateLimit
+func (s *Server) TokenRequests(token string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.tokens[token]; ok {
+		return t.requests
+	}
+	return 0
+}
+
+// FailNext makes the next n requests fail with status. Non-empty retryAfter
+// is sent as Retry-After header. 403 responses carry a secondary rate
+// limit message, same as GitHub does.
+func (s *Server) FailNext(n, status int, retryAfter string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message := http.StatusText(status)
+	if status == http.StatusForbidden {
+		message = "You have exceeded a secondary rate limit. Please wait a few minutes before you try again."
+	}
+
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, failure{status: status, retryAfter: retryAfter, message: message})
+	}
+}
+
+// Requests returns count of requests served
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+
+	if t, ok := s.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "token ")]; ok {
+		t.requests++
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(s.limit))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(t.reset.Unix(), 10))
+
+		if t.remaining == 0 {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			writeMessage(w, http.StatusForbidden, "API rate limit exceeded")
+			return
+		}
+
+		t.remaining--
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(t.remaining))
+	} else {
+		if s.remaining > 0 {
+			s.remaining--
+		}
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(s.limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(s.remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(s.reset.Unix(), 10))
+	}
+
+	if len(s.failures) > 0 {
+		f := s.failures[0]
+		s.failures = s.failures[1:]
+
+		if f.retryAfter != "" {
+			w.Header().Set("Retry-After", f.retryAfter)
+		}
+		writeMessage(w, f.status, f.message)
+		return
+	}
+
+	if r.Method == "POST" && r.URL.Path == "/graphql" {
+		s.graphql(w, r)
+		return
+	}
+
+	if r.Method != "GET" {
+		writeMessage(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case r.URL.Path == "/search/repositories":
+		s.search(w, r)
+	case len(parts) >= 3 && parts[0] == "repos":
+		repo, ok := s.repos[strings.ToLower(parts[1]+"/"+parts[2])]
+		if !ok {
+			writeMessage(w, http.StatusNotFound, "Not Found")
+			return
+		}
+
+		switch {
+		case len(parts) == 3:
+			writeJSON(w, r, repo.Item)
+		case len(parts) == 4 && parts[3] == "readme":
+			writeRaw(w, r, repo.Readme)
+		case len(parts) == 5 && parts[3] == "contents" && parts[4] == "go.mod":
+			writeRaw(w, r, repo.GoMod)
+		default:
+			writeMessage(w, http.StatusNotFound, "Not Found")
+		}
+	default:
+		writeMessage(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+// starsQualifier matches `stars:N..M`, `stars:>=N` and `stars:N` qualifiers
+var starsQualifier = regexp.MustCompile(`stars:(>=)?(\d+)(?:\.\.(\d+))?`)
+
+// search returns repositories sorted by stars. Only `stars:` qualifier of
+// the query is respected, along with `page` and `per_page`. Same as GitHub
+// does, only the first 1000 results are served.
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil || perPage < 1 {
+		perPage = 30
+	}
+
+	minStars, maxStars := 0, -1
+	if match := starsQualifier.FindStringSubmatch(r.URL.Query().Get("q")); match != nil {
+		minStars, _ = strconv.Atoi(match[2])
+		switch {
+		case match[3] != "":
+			maxStars, _ = strconv.Atoi(match[3])
+		case match[1] == "":
+			maxStars = minStars
+		}
+	}
+
+	items := []structs.Item{}
+	for _, repo := range s.repos {
+		stars := repo.Item.StargazersCount
+		if stars >= minStars && (maxStars < 0 || stars <= maxStars) {
+			items = append(items, repo.Item)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].StargazersCount == items[j].StargazersCount {
+			return items[i].FullName < items[j].FullName
+		}
+		return items[i].StargazersCount > items[j].StargazersCount
+	})
+
+	body := struct {
+		TotalCount        int            `json:"total_count"`
+		IncompleteResults bool           `json:"incomplete_results"`
+		Items             []structs.Item `json:"items"`
+	}{TotalCount: len(items), Items: []structs.Item{}}
+
+	start := (page - 1) * perPage
+	if start < len(items) && start < searchCap {
+		end := start + perPage
+		if end > len(items) {
+			end = len(items)
+		}
+		if end > searchCap {
+			end = searchCap
+		}
+		body.Items = items[start:end]
+	}
+
+	writeJSON(w, r, body)
+}
+
+// repositoryField matches `alias: repository(owner: $o, name: $n)` fields
+var repositoryField = regexp.MustCompile(`(\w+)\s*:\s*repository\(\s*owner\s*:\s*\$(\w+)\s*,\s*name\s*:\s*\$(\w+)\s*\)`)
+
+// graphql answers aliased `repository` fields of a query, the only GraphQL
+// shape the client sends. Unknown repositories are null with a NOT_FOUND error.
+func (s *Server) graphql(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Query     string            `json:"query"`
+		Variables map[string]string `json:"variables"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeMessage(w, http.StatusBadRequest, "Problems parsing JSON")
+		return
+	}
+
+	data := make(map[string]interface{})
+	errors := []map[string]interface{}{}
+
+	for _, match := range repositoryField.FindAllStringSubmatch(body.Query, -1) {
+		alias := match[1]
+		key := strings.ToLower(body.Variables[match[2]] + "/" + body.Variables[match[3]])
+
+		repo, ok := s.repos[key]
+		if !ok {
+			data[alias] = nil
+			errors = append(errors, map[string]interface{}{
+				"type":    "NOT_FOUND",
+				"path":    []string{alias},
+				"message": "Could not resolve to a Repository with the name '" + key + "'.",
+			})
+			continue
+		}
+
+		var gomod interface{}
+		if repo.GoMod != "" {
+			gomod = map[string]string{"text": repo.GoMod}
+		}
+
+		data[alias] = map[string]interface{}{
+			"name":             repo.Item.Name,
+			"nameWithOwner":    repo.Item.FullName,
+			"url":              repo.Item.HTMLURL,
+			"description":      repo.Item.Description,
+			"stargazerCount":   repo.Item.StargazersCount,
+			"forkCount":        repo.Item.ForksCount,
+			"owner":            map[string]string{"avatarUrl": repo.Item.Owner.AvatarURL},
+			"defaultBranchRef": map[string]string{"name": repo.DefaultBranch},
+			"gomod":            gomod,
+		}
+	}
+
+	response := map[string]interface{}{"data": data}
+	if len(errors) > 0 {
+		response["errors"] = errors
+	}
+
+	w.Header().Set("X-RateLimit-Resource", "graphql")
+	writeJSON(w, r, response)
+}
+
+func readOptional(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return string(data), err
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeMessage(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writeBody(w, r, data)
+}
+
+func writeRaw(w http.ResponseWriter, r *http.Request, content string) {
+	if content == "" {
+		writeMessage(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	writeBody(w, r, []byte(content))
+}
+
+// writeBody writes body with an ETag. Responds `304 Not Modified` if
+// request's If-None-Match matches the ETag.
+func writeBody(w http.ResponseWriter, r *http.Request, body []byte) {
+	etag := fmt.Sprintf(`"%x"`, sha1.Sum(body))
+	w.Header().Set("ETag", etag)
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// writeMessage writes error body in the same format GitHub does
+func writeMessage(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"message":%q,"documentation_url":"https://developer.github.com/v3"}`, message)
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// GraphQLBatchSize is a count of repositories fetched by a single GraphQL query
+const GraphQLBatchSize = 50
+
+// RepoInfo is repository metadata fetched with GraphQL. GoMod is empty if
+// repository has no go.mod in its default branch.
+type RepoInfo struct {
+	Name            string
+	FullName        string
+	HTMLURL         string
+	Description     string
+	StargazersCount int
+	ForksCount      int
+	AvatarURL       string
+	DefaultBranch   string
+	GoMod           string
+}
+
+// graphqlRepo is a `repository` object selected by repoFragment
+type graphqlRepo struct {
+	Name           string `json:"name"`
+	NameWithOwner  string `json:"nameWithOwner"`
+	URL            string `json:"url"`
+	Description    string `json:"description"`
+	StargazerCount int    `json:"stargazerCount"`
+	ForkCount      int    `json:"forkCount"`
+	Owner          struct {
+		AvatarURL string `json:"avatarUrl"`
+	} `json:"owner"`
+	DefaultBranchRef *struct {
+		Name string `json:"name"`
+	} `json:"defaultBranchRef"`
+	GoMod *struct {
+		Text string `json:"text"`
+	} `json:"gomod"`
+}
+
+const repoFragment = `
+fragment repo on Repository {
+	name
+	nameWithOwner
+	url
+	description
+	stargazerCount
+	forkCount
+	owner { avatarUrl }
+	defaultBranchRef { name }
+	gomod: object(expression: "HEAD:go.mod") { ... on Blob { text } }
+}`
+
+// GraphQLError is a single error of GraphQL response
+type GraphQLError struct {
+	Type    string   `json:"type"`
+	Path    []string `json:"path"`
+	Message string   `json:"message"`
+}
+
+// GraphQL sends query with variables to GitHub GraphQL API and decodes
+// `data` of response to v. Response errors are returned along with data,
+// callers decide which of them are fatal.
+func (gh *GitHubClient) GraphQL(query string, variables map[string]interface{}, v interface{}) ([]GraphQLError, error) {
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", gh.gqlURL.String(), buf)
+	if err != nil {
+		return nil, err
+	}
+
+	var body struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []GraphQLError  `json:"errors"`
+	}
+
+	resp, err := gh.DoJson(req, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GraphQL %v", resp.Status)
+	}
+
+	if len(body.Data) == 0 || bytes.Equal(body.Data, []byte("null")) {
+		if len(body.Errors) > 0 {
+			return body.Errors, fmt.Errorf("GraphQL: %v", body.Errors[0].Message)
+		}
+		return nil, fmt.Errorf("GraphQL: empty response")
+	}
+
+	return body.Errors, json.Unmarshal(body.Data, v)
+}
+
+// BatchRepos fetches metadata and go.mod of repositories with keys in
+// `owner/repo` format, GraphQLBatchSize repositories per request. Returned
+// map is keyed by lower cased key, repositories not found are missing.
+func (gh *GitHubClient) BatchRepos(keys []string) (map[string]RepoInfo, error) {
+	result := make(map[string]RepoInfo)
+
+	for start := 0; start < len(keys); start += GraphQLBatchSize {
+		end := start + GraphQLBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		if err := gh.batchRepos(keys[start:end], result); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+func (gh *GitHubClient) batchRepos(keys []string, result map[string]RepoInfo) error {
+	params := []string{}
+	fields := []string{}
+	variables := make(map[string]interface{})
+	aliases := make(map[string]string)
+
+	for i, key := range keys {
+		parts := strings.SplitN(key, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		alias := fmt.Sprintf("r%d", i)
+		params = append(params, fmt.Sprintf("$o%d: String!, $n%d: String!", i, i))
+		fields = append(fields, fmt.Sprintf("\t%s: repository(owner: $o%d, name: $n%d) { ...repo }", alias, i, i))
+		variables[fmt.Sprintf("o%d", i)] = parts[0]
+		variables[fmt.Sprintf("n%d", i)] = parts[1]
+		aliases[alias] = strings.ToLower(key)
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	query := fmt.Sprintf("query(%s) {\n%s\n}\n%s", strings.Join(params, ", "), strings.Join(fields, "\n"), repoFragment)
+
+	data := make(map[string]*graphqlRepo)
+
+	// NOT_FOUND errors come with null repositories, they are skipped below
+	if _, err := gh.GraphQL(query, variables, &data); err != nil {
+		return err
+	}
+
+	for alias, repo := range data {
+		if repo == nil {
+			continue
+		}
+
+		info := RepoInfo{
+			Name:            repo.Name,
+			FullName:        repo.NameWithOwner,
+			HTMLURL:         repo.URL,
+			Description:     repo.Description,
+			StargazersCount: repo.StargazerCount,
+			ForksCount:      repo.ForkCount,
+			AvatarURL:       repo.Owner.AvatarURL,
+		}
+		if repo.DefaultBranchRef != nil {
+			info.DefaultBranch = repo.DefaultBranchRef.Name
+		}
+		if repo.GoMod != nil {
+			info.GoMod = repo.GoMod.Text
+		}
+
+		result[aliases[alias]] = info
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/a-sube/go-repos-api/gh-client/fakegh"
+	"github.com/a-sube/go-repos-api/structs"
+)
+
+func TestGraphqlURL(t *testing.T) {
+	tests := []struct {
+		base, want string
+	}{
+		{"https://api.github.com", "https://api.github.com/graphql"},
+		{"https://api.github.com/", "https://api.github.com/graphql"},
+		{"https://github.example.com/api/v3", "https://github.example.com/api/graphql"},
+		{"https://github.example.com/api/v3/", "https://github.example.com/api/graphql"},
+		{"http://127.0.0.1:8080/github", "http://127.0.0.1:8080/github/graphql"},
+	}
+
+	for _, test := range tests {
+		base, err := url.Parse(test.base)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := graphqlURL(base).String(); got != test.want {
+			t.Errorf("graphqlURL(%q) = %q, want %q", test.base, got, test.want)
+		}
+	}
+}
+
+func TestBatchRepos(t *testing.T) {
+	server := fakegh.NewServer()
+	defer server.Close()
+
+	server.AddRepo(structs.Item{Name: "mux", FullName: "gorilla/mux", StargazersCount: 100}, testGoMod, "")
+	server.AddRepo(structs.Item{Name: "websocket", FullName: "gorilla/websocket", StargazersCount: 50}, "", "")
+	server.SetDefaultBranch("gorilla/websocket", "main")
+
+	gh, err := NewGitHubClient(server.URL, "token", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := gh.BatchRepos([]string{"gorilla/mux", "Gorilla/WebSocket", "gorilla/nope", "invalid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(infos) != 2 {
+		t.Errorf("%d repos fetched, want 2: %+v", len(infos), infos)
+	}
+
+	mux := infos["gorilla/mux"]
+	if mux.FullName != "gorilla/mux" || mux.StargazersCount != 100 || mux.GoMod != testGoMod || mux.DefaultBranch != "master" {
+		t.Errorf("gorilla/mux %+v, want 100 stars, go.mod and master branch", mux)
+	}
+
+	websocket, ok := infos["gorilla/websocket"]
+	if !ok || websocket.StargazersCount != 50 || websocket.GoMod != "" || websocket.DefaultBranch != "main" {
+		t.Errorf("gorilla/websocket %+v, want 50 stars, no go.mod and main branch", websocket)
+	}
+
+	if _, ok := infos["gorilla/nope"]; ok {
+		t.Errorf("missing repo gorilla/nope fetched")
+	}
+
+	if server.Requests() != 1 {
+		t.Errorf("%d requests served, want 1", server.Requests())
+	}
+	if gh.RequestsMade() != 0 {
+		t.Errorf("%d core requests made, want 0, GraphQL has its own bucket", gh.RequestsMade())
+	}
+}
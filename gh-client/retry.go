@@ -0,0 +1,82 @@
+package client
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxRetries is how many times a request is retried before giving up
+	maxRetries = 6
+	// maxBackoff caps exponential backoff between retries
+	maxBackoff = time.Minute * 5
+	// secondaryBackoff is a minimal wait after a secondary rate limit response
+	// without Retry-After header, as GitHub docs recommend
+	secondaryBackoff = time.Minute
+)
+
+// retryDelay reports whether response should be retried and how long to wait.
+//
+// Retry-After header of 403 and 429 responses is honored. Primary limit
+// responses (X-RateLimit-Remaining: 0) are retried right away, pickToken
+// switches token or sleeps until reset. Other secondary rate limit and abuse
+// detection responses and 5xx errors are retried with exponential backoff.
+func retryDelay(resp *http.Response, body []byte, attempt int) (time.Duration, bool) {
+	backoff := time.Second << uint(attempt)
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	if resp == nil {
+		return backoff, true
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests:
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			return time.Second * time.Duration(seconds), true
+		}
+
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			return 0, true
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests || isSecondaryLimit(body) {
+			if backoff < secondaryBackoff {
+				backoff = secondaryBackoff
+			}
+			return backoff, true
+		}
+	case resp.StatusCode == http.StatusInternalServerError ||
+		resp.StatusCode == http.StatusBadGateway ||
+		resp.StatusCode == http.StatusServiceUnavailable ||
+		resp.StatusCode == http.StatusGatewayTimeout:
+		return backoff, true
+	}
+
+	return 0, false
+}
+
+// isSecondaryLimit reports whether 403 response body is a secondary rate
+// limit or abuse detection message
+func isSecondaryLimit(body []byte) bool {
+	body = bytes.ToLower(body)
+	return bytes.Contains(body, []byte("secondary rate limit")) ||
+		bytes.Contains(body, []byte("abuse"))
+}
+
+// rewind resets request body before retry
+func rewind(req *http.Request) error {
+	if req.Body == nil || req.GetBody == nil {
+		return nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+	req.Body = body
+	return nil
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryDelays(t *testing.T) {
+	tests := []struct {
+		name       string
+		fail       int
+		status     int
+		retryAfter string
+		sleeps     []time.Duration
+	}{
+		{"retry after of 429", 1, http.StatusTooManyRequests, "7", []time.Duration{time.Second * 7}},
+		{"retry after of 403", 1, http.StatusForbidden, "3", []time.Duration{time.Second * 3}},
+		{"secondary rate limit", 1, http.StatusForbidden, "", []time.Duration{secondaryBackoff}},
+		{"429 without retry after", 1, http.StatusTooManyRequests, "", []time.Duration{secondaryBackoff}},
+		{"bad gateway backoff", 3, http.StatusBadGateway, "", []time.Duration{time.Second, time.Second * 2, time.Second * 4}},
+	}
+
+	for _, test := range tests {
+		gh, server := newTestClient(t)
+		clock := newFakeClock(gh)
+
+		server.FailNext(test.fail, test.status, test.retryAfter)
+
+		gomod, err := gh.GetRawContent("/repos/gorilla/mux/contents/go.mod")
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if gomod != testGoMod {
+			t.Errorf("%s: go.mod %q, want %q", test.name, gomod, testGoMod)
+		}
+
+		if n := server.Requests(); n != test.fail+1 {
+			t.Errorf("%s: %d requests served, want %d", test.name, n, test.fail+1)
+		}
+		if !equalDurations(clock.sleeps, test.sleeps) {
+			t.Errorf("%s: slept %v, want %v", test.name, clock.sleeps, test.sleeps)
+		}
+
+		server.Close()
+	}
+}
+
+func TestNotRetried(t *testing.T) {
+	tests := []struct {
+		name   string
+		fail   int
+		path   string
+		status int
+	}{
+		{"not found", 0, "/repos/gorilla/nope", http.StatusNotFound},
+		{"unprocessable entity", http.StatusUnprocessableEntity, "/repos/gorilla/mux", http.StatusUnprocessableEntity},
+	}
+
+	for _, test := range tests {
+		gh, server := newTestClient(t)
+		clock := newFakeClock(gh)
+
+		if test.fail != 0 {
+			server.FailNext(1, test.fail, "")
+		}
+
+		_, err := gh.GetRawContent(test.path)
+
+		statusErr, ok := err.(*StatusError)
+		if !ok || statusErr.StatusCode != test.status {
+			t.Errorf("%s: error %v, want *StatusError %d", test.name, err, test.status)
+		}
+		if n := server.Requests(); n != 1 {
+			t.Errorf("%s: %d requests served, want 1", test.name, n)
+		}
+		if len(clock.sleeps) != 0 {
+			t.Errorf("%s: slept %v, want no retry", test.name, clock.sleeps)
+		}
+
+		server.Close()
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	gh, server := newTestClient(t)
+	defer server.Close()
+
+	clock := newFakeClock(gh)
+	server.FailNext(maxRetries+1, http.StatusServiceUnavailable, "")
+
+	_, err := gh.GetRawContent("/repos/gorilla/mux/contents/go.mod")
+
+	statusErr, ok := err.(*StatusError)
+	if !ok || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("error %v, want *StatusError 503", err)
+	}
+	if n := server.Requests(); n != maxRetries+1 {
+		t.Errorf("%d requests served, want %d", n, maxRetries+1)
+	}
+	if len(clock.sleeps) != maxRetries {
+		t.Errorf("slept %v, want %d backoffs", clock.sleeps, maxRetries)
+	}
+}
+
+func equalDurations(a, b []time.Duration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/a-sube/go-repos-api/utils"
+)
+
+// GitHub rate limit buckets. Search and GraphQL APIs have their own limits.
+const (
+	coreBucket    = "core"
+	searchBucket  = "search"
+	graphqlBucket = "graphql"
+)
+
+// bucket is a rate limit budget of a single token
+type bucket struct {
+	// known is false until first response with rate limit headers
+	// and after the reset time passes
+	known     bool
+	limit     int
+	resetTime int64
+}
+
+// tokenState is a single access token with its rate limit budgets
+type tokenState struct {
+	value    string
+	buckets  map[string]*bucket
+	requests int
+}
+
+func newTokenState(value string) *tokenState {
+	return &tokenState{
+		value: value,
+		buckets: map[string]*bucket{
+			coreBucket:    {},
+			searchBucket:  {},
+			graphqlBucket: {},
+		},
+	}
+}
+
+// bucketOf returns rate limit bucket request is counted against
+func (gh *GitHubClient) bucketOf(req *http.Request) string {
+	if req.URL.Path == gh.gqlURL.Path {
+		return graphqlBucket
+	}
+	path := strings.TrimPrefix(req.URL.Path, strings.TrimSuffix(gh.ghURL.Path, "/"))
+	if strings.HasPrefix(path, "/search/") {
+		return searchBucket
+	}
+	return coreBucket
+}
+
+// minRemaining is a count of requests left unused in a bucket
+func minRemaining(name string) int {
+	if name == searchBucket {
+		return 0
+	}
+	return 2
+}
+
+// pickToken returns token with the most remaining budget in bucket name and
+// reserves one request of it, so concurrent requests share the budget. Tokens
+// with unknown budget are tried first. When all tokens are about to reach
+// their limit sleeps until the earliest reset.
+func (gh *GitHubClient) pickToken(name string) *tokenState {
+	for {
+		gh.mu.Lock()
+
+		now := gh.now().Unix()
+		earliest := int64(0)
+
+		var best *tokenState
+		for _, t := range gh.tokens {
+			b := t.buckets[name]
+			if b.known && b.resetTime <= now {
+				b.known = false
+			}
+
+			if !b.known {
+				best = t
+				break
+			}
+
+			if best == nil || b.limit > best.buckets[name].limit {
+				best = t
+			}
+			if earliest == 0 || b.resetTime < earliest {
+				earliest = b.resetTime
+			}
+		}
+
+		b := best.buckets[name]
+		if !b.known || b.limit > minRemaining(name) {
+			if b.known {
+				b.limit--
+			}
+			best.requests++
+			gh.mu.Unlock()
+			return best
+		}
+
+		gh.mu.Unlock()
+		gh.sleep(time.Second * time.Duration(earliest-now))
+	}
+}
+
+// setLimit sets budget of token from rate limit headers of response. Bucket
+// is taken from X-RateLimit-Resource header, name is used if it is missing.
+func (gh *GitHubClient) setLimit(t *tokenState, name string, header http.Header) {
+	limit, limitErr := utils.StrToInt(header.Get("X-RateLimit-Remaining"))
+	reset, resetErr := utils.StrToInt(header.Get("X-RateLimit-Reset"))
+	if limitErr != nil || resetErr != nil {
+		return
+	}
+
+	if resource := header.Get("X-RateLimit-Resource"); t.buckets[resource] != nil {
+		name = resource
+	}
+
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+
+	b := t.buckets[name]
+	b.known = true
+	b.limit = limit
+	b.resetTime = int64(reset)
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/a-sube/go-repos-api/gh-client/fakegh"
+	"github.com/a-sube/go-repos-api/structs"
+)
+
+// fakeClock replaces clock of client, sleeping moves it forward at once
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func newFakeClock(gh *GitHubClient) *fakeClock {
+	c := &fakeClock{now: time.Now().Truncate(time.Second)}
+	gh.now = func() time.Time { return c.now }
+	gh.sleep = func(d time.Duration) {
+		c.sleeps = append(c.sleeps, d)
+		c.now = c.now.Add(d)
+	}
+	return c
+}
+
+// newTokensClient returns a client of a fake server sending requests with
+// tokens "a" and "b"
+func newTokensClient(t *testing.T) (*GitHubClient, *fakegh.Server) {
+	t.Helper()
+
+	server := fakegh.NewServer()
+	server.AddRepo(structs.Item{FullName: "gorilla/mux", StargazersCount: 100}, testGoMod, "")
+
+	gh, err := NewGitHubClientWithTokens(server.URL, []string{"a", "b"}, nil)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+
+	return gh, server
+}
+
+func getGoMod(t *testing.T, gh *GitHubClient, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		if _, err := gh.GetRawContent("/repos/gorilla/mux/contents/go.mod"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPickTokenMostRemaining(t *testing.T) {
+	gh, server := newTokensClient(t)
+	defer server.Close()
+
+	reset := time.Now().Add(time.Hour)
+	server.SetTokenRateLimit("a", 10, reset)
+	server.SetTokenRateLimit("b", 100, reset)
+
+	// both unknown first, then b has more remaining
+	getGoMod(t, gh, 5)
+
+	if a, b := server.TokenRequests("a"), server.TokenRequests("b"); a != 1 || b != 4 {
+		t.Errorf("requests of a %d and b %d, want 1 and 4", a, b)
+	}
+}
+
+func TestPickTokenExhaustedFallback(t *testing.T) {
+	gh, server := newTokensClient(t)
+	defer server.Close()
+
+	reset := time.Now().Add(time.Hour)
+	server.SetTokenRateLimit("a", 100, reset)
+	server.SetTokenRateLimit("b", 50, reset)
+
+	// a is left with 99 requests, b with 49
+	getGoMod(t, gh, 2)
+
+	// a is exhausted by other clients, its rate limited response is retried
+	// with b, and b is used from then on
+	server.SetTokenRateLimit("a", 0, reset)
+	getGoMod(t, gh, 2)
+
+	if a := server.TokenRequests("a"); a != 1 {
+		t.Errorf("%d requests of a after it was exhausted, want 1", a)
+	}
+	if b := server.TokenRequests("b"); b != 3 {
+		t.Errorf("%d requests of b, want 3", b)
+	}
+
+	a := gh.tokens[0].buckets[coreBucket]
+	if !a.known || a.limit != 0 {
+		t.Errorf("bucket of a %+v, want limit 0", *a)
+	}
+}
+
+func TestPickTokenWaitsForEarliestReset(t *testing.T) {
+	gh, server := newTokensClient(t)
+	defer server.Close()
+
+	clock := newFakeClock(gh)
+
+	server.SetTokenRateLimit("a", 3, clock.now.Add(time.Minute*30))
+	server.SetTokenRateLimit("b", 3, clock.now.Add(time.Minute*10))
+
+	// both tokens are left with 2 requests
+	getGoMod(t, gh, 2)
+	if len(clock.sleeps) != 0 {
+		t.Fatalf("slept %v before tokens reached their limit", clock.sleeps)
+	}
+
+	getGoMod(t, gh, 1)
+
+	if len(clock.sleeps) != 1 || clock.sleeps[0] != time.Minute*10 {
+		t.Errorf("slept %v, want 10m until reset of b", clock.sleeps)
+	}
+	if b := server.TokenRequests("b"); b != 2 {
+		t.Errorf("%d requests of b, want 2, b is reset first", b)
+	}
+}
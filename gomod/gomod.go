@@ -0,0 +1,277 @@
+// Package gomod parses go.mod files.
+package gomod
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// File is a parsed go.mod file
+type File struct {
+	Module  string
+	Go      string
+	Require []Require
+	Replace []Replace
+	Exclude []Version
+	Retract []Retract
+}
+
+// Version is a module path and version pair. Version is empty for
+// replacements pointing to a local directory.
+type Version struct {
+	Path    string
+	Version string
+}
+
+// String returns path and version separated by space
+func (v Version) String() string {
+	if v.Version == "" {
+		return v.Path
+	}
+	return v.Path + " " + v.Version
+}
+
+// Require is a single `require` directive
+type Require struct {
+	Path     string
+	Version  string
+	Indirect bool
+}
+
+// Replace is a single `replace` directive. Old.Version is empty when
+// all versions of a module are replaced.
+type Replace struct {
+	Old Version
+	New Version
+}
+
+// Retract is a single `retract` directive. Low and High are equal for a
+// single retracted version.
+type Retract struct {
+	Low       string
+	High      string
+	Rationale string
+}
+
+// Parse parses go.mod content. Unknown directives are skipped.
+func Parse(data string) (*File, error) {
+	f := &File{}
+	block := ""
+
+	for n, line := range strings.Split(data, "\n") {
+		tokens, comment, err := tokenize(line)
+		if err != nil {
+			return nil, fmt.Errorf("go.mod:%d: %v", n+1, err)
+		}
+
+		if len(tokens) == 0 {
+			continue
+		}
+
+		if block != "" {
+			if tokens[0] == ")" {
+				block = ""
+				continue
+			}
+			if err := f.add(block, tokens, comment); err != nil {
+				return nil, fmt.Errorf("go.mod:%d: %v", n+1, err)
+			}
+			continue
+		}
+
+		if len(tokens) == 2 && tokens[1] == "(" {
+			block = tokens[0]
+			continue
+		}
+
+		if err := f.add(tokens[0], tokens[1:], comment); err != nil {
+			return nil, fmt.Errorf("go.mod:%d: %v", n+1, err)
+		}
+	}
+
+	if block != "" {
+		return nil, fmt.Errorf("go.mod: unterminated %s block", block)
+	}
+
+	return f, nil
+}
+
+// Replacement returns replacement for module path at version or nil if
+// module is not replaced. Version specific replacement wins.
+func (f *File) Replacement(path, version string) *Replace {
+	var found *Replace
+
+	for i := range f.Replace {
+		r := &f.Replace[i]
+		if r.Old.Path != path {
+			continue
+		}
+		if r.Old.Version == version {
+			return r
+		}
+		if r.Old.Version == "" {
+			found = r
+		}
+	}
+
+	return found
+}
+
+func (f *File) add(verb string, args []string, comment string) error {
+	switch verb {
+	case "module":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: module module/path")
+		}
+		f.Module = args[0]
+	case "go":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: go 1.23")
+		}
+		f.Go = args[0]
+	case "require":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: require module/path v1.2.3")
+		}
+		f.Require = append(f.Require, Require{
+			Path:     args[0],
+			Version:  args[1],
+			Indirect: isIndirect(comment),
+		})
+	case "exclude":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: exclude module/path v1.2.3")
+		}
+		f.Exclude = append(f.Exclude, Version{Path: args[0], Version: args[1]})
+	case "replace":
+		r, err := parseReplace(args)
+		if err != nil {
+			return err
+		}
+		f.Replace = append(f.Replace, r)
+	case "retract":
+		r, err := parseRetract(args)
+		if err != nil {
+			return err
+		}
+		r.Rationale = comment
+		f.Retract = append(f.Retract, r)
+	}
+
+	return nil
+}
+
+func parseReplace(args []string) (Replace, error) {
+	var r Replace
+
+	arrow := -1
+	for i, arg := range args {
+		if arg == "=>" {
+			arrow = i
+			break
+		}
+	}
+
+	if arrow < 1 || arrow > 2 || len(args)-arrow-1 < 1 || len(args)-arrow-1 > 2 {
+		return r, fmt.Errorf("usage: replace module/path [v1.2.3] => other/module [v1.4.5]")
+	}
+
+	r.Old.Path = args[0]
+	if arrow == 2 {
+		r.Old.Version = args[1]
+	}
+
+	r.New.Path = args[arrow+1]
+	if len(args) == arrow+3 {
+		r.New.Version = args[arrow+2]
+	}
+
+	return r, nil
+}
+
+func parseRetract(args []string) (Retract, error) {
+	var r Retract
+
+	switch {
+	case len(args) == 1:
+		r.Low, r.High = args[0], args[0]
+	case len(args) == 5 && args[0] == "[" && args[2] == "," && args[4] == "]":
+		r.Low, r.High = args[1], args[3]
+	default:
+		return r, fmt.Errorf("usage: retract v1.2.3 or retract [v1.0.0, v1.2.3]")
+	}
+
+	return r, nil
+}
+
+// isIndirect reports whether line comment marks requirement as indirect.
+// Comment may contain more text after `indirect;`.
+func isIndirect(comment string) bool {
+	return comment == "indirect" || strings.HasPrefix(comment, "indirect;")
+}
+
+// tokenize splits line into tokens and trailing `//` comment. Quoted and
+// backquoted strings are unquoted. Parentheses, brackets and commas are
+// separate tokens.
+func tokenize(line string) ([]string, string, error) {
+	tokens := []string{}
+
+	for i := 0; i < len(line); {
+		c := line[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(line[i:], "//"):
+			return tokens, strings.TrimSpace(line[i+2:]), nil
+		case c == '(' || c == ')' || c == '[' || c == ']' || c == ',':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"' || c == '`':
+			end := i + 1
+			for end < len(line) && line[end] != c {
+				if c == '"' && line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, "", fmt.Errorf("unterminated quoted string")
+			}
+
+			token := line[i+1 : end]
+			if c == '"' {
+				unquoted, err := strconv.Unquote(line[i : end+1])
+				if err != nil {
+					return nil, "", err
+				}
+				token = unquoted
+			}
+
+			tokens = append(tokens, token)
+			i = end + 1
+		default:
+			end := i
+			for end < len(line) && !strings.ContainsRune(" \t\r()[],\"`", rune(line[end])) &&
+				!strings.HasPrefix(line[end:], "//") {
+				end++
+			}
+			tokens = append(tokens, line[i:end])
+			i = end
+		}
+	}
+
+	return tokens, "", nil
+}
+
+// GitHubRepo returns `owner/repo` for module paths hosted on github.com.
+// Subdirectories and major version suffixes are dropped.
+func GitHubRepo(path string) (string, bool) {
+	parts := strings.Split(path, "/")
+	if len(parts) < 3 || parts[0] != "github.com" || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+
+	return strings.ToLower(parts[1] + "/" + parts[2]), true
+}
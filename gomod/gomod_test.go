@@ -0,0 +1,177 @@
+package gomod
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want *File
+	}{
+		{
+			name: "module and go",
+			data: "module github.com/gin-gonic/gin\n\ngo 1.13\n",
+			want: &File{Module: "github.com/gin-gonic/gin", Go: "1.13"},
+		},
+		{
+			name: "single-line require",
+			data: "module m\nrequire github.com/ugorji/go v1.1.7\n",
+			want: &File{
+				Module:  "m",
+				Require: []Require{{Path: "github.com/ugorji/go", Version: "v1.1.7"}},
+			},
+		},
+		{
+			name: "block require",
+			data: "require (\n\tgithub.com/mattn/go-isatty v0.0.12\n\n\tgolang.org/x/sys v0.0.0-20200116001909-b77594299b42\n)\n",
+			want: &File{Require: []Require{
+				{Path: "github.com/mattn/go-isatty", Version: "v0.0.12"},
+				{Path: "golang.org/x/sys", Version: "v0.0.0-20200116001909-b77594299b42"},
+			}},
+		},
+		{
+			name: "indirect",
+			data: "require (\n\ta v1.0.0 // indirect\n\tb v1.0.0 // indirect; for tests\n\tc v1.0.0 // not indirect\n)\nrequire d v1.0.0 //indirect\n",
+			want: &File{Require: []Require{
+				{Path: "a", Version: "v1.0.0", Indirect: true},
+				{Path: "b", Version: "v1.0.0", Indirect: true},
+				{Path: "c", Version: "v1.0.0"},
+				{Path: "d", Version: "v1.0.0", Indirect: true},
+			}},
+		},
+		{
+			name: "replace with and without version",
+			data: "replace a v1.0.0 => b v1.1.0\nreplace (\n\tc => ../c\n\td v1.2.3 => ./d\n\te => f v0.1.0\n)\n",
+			want: &File{Replace: []Replace{
+				{Old: Version{"a", "v1.0.0"}, New: Version{"b", "v1.1.0"}},
+				{Old: Version{Path: "c"}, New: Version{Path: "../c"}},
+				{Old: Version{"d", "v1.2.3"}, New: Version{Path: "./d"}},
+				{Old: Version{Path: "e"}, New: Version{"f", "v0.1.0"}},
+			}},
+		},
+		{
+			name: "quoted paths",
+			data: "module \"example.com/quoted\"\nrequire `example.com/raw` \"v1.0.0\"\nreplace \"example.com/a b\" => \"./a b\"\n",
+			want: &File{
+				Module:  "example.com/quoted",
+				Require: []Require{{Path: "example.com/raw", Version: "v1.0.0"}},
+				Replace: []Replace{{Old: Version{Path: "example.com/a b"}, New: Version{Path: "./a b"}}},
+			},
+		},
+		{
+			name: "exclude and retract",
+			data: "exclude golang.org/x/net v1.2.3\nexclude (\n\tgolang.org/x/text v0.3.0\n)\nretract v1.0.1 // broken build\nretract (\n\t[v1.1.0, v1.1.9]\n)\n",
+			want: &File{
+				Exclude: []Version{{"golang.org/x/net", "v1.2.3"}, {"golang.org/x/text", "v0.3.0"}},
+				Retract: []Retract{
+					{Low: "v1.0.1", High: "v1.0.1", Rationale: "broken build"},
+					{Low: "v1.1.0", High: "v1.1.9"},
+				},
+			},
+		},
+		{
+			name: "comments",
+			data: "// Copyright\nmodule m // the module\ngo 1.16// no space\nrequire (// deps\n\ta v1.0.0\n) // end\n",
+			want: &File{
+				Module:  "m",
+				Go:      "1.16",
+				Require: []Require{{Path: "a", Version: "v1.0.0"}},
+			},
+		},
+		{
+			name: "unknown directives skipped",
+			data: "module m\ntoolchain go1.21.0\ngodebug default=go1.21\n",
+			want: &File{Module: "m"},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := Parse(test.data)
+		if err != nil {
+			t.Errorf("%s: Parse() error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Parse() = %+v; want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"unterminated block", "module m\nrequire (\n\ta v1.0.0\n", "unterminated require block"},
+		{"unterminated quote", "module \"m\n", "go.mod:1: unterminated quoted string"},
+		{"require without version", "require a\n", "go.mod:1: usage: require"},
+		{"replace without arrow", "replace a v1.0.0 b v1.1.0\n", "go.mod:1: usage: replace"},
+		{"replace without target", "replace (\n\ta =>\n)\n", "go.mod:2: usage: replace"},
+		{"bad retract range", "retract [v1.0.0 v1.1.0]\n", "go.mod:1: usage: retract"},
+		{"two module paths", "module a b\n", "go.mod:1: usage: module"},
+	}
+
+	for _, test := range tests {
+		f, err := Parse(test.data)
+		if err == nil {
+			t.Errorf("%s: Parse() = %+v; want error", test.name, f)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: Parse() error %q; want %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestReplacement(t *testing.T) {
+	f := &File{Replace: []Replace{
+		{Old: Version{Path: "a"}, New: Version{Path: "../a"}},
+		{Old: Version{"a", "v1.0.0"}, New: Version{"b", "v1.0.1"}},
+	}}
+
+	tests := []struct {
+		path    string
+		version string
+		want    string
+	}{
+		{"a", "v1.0.0", "b v1.0.1"},
+		{"a", "v2.0.0", "../a"},
+		{"b", "v1.0.0", ""},
+	}
+
+	for _, test := range tests {
+		got := ""
+		if r := f.Replacement(test.path, test.version); r != nil {
+			got = r.New.String()
+		}
+		if got != test.want {
+			t.Errorf("Replacement(%q, %q) = %q; want %q", test.path, test.version, got, test.want)
+		}
+	}
+}
+
+func TestGitHubRepo(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"github.com/gin-gonic/gin", "gin-gonic/gin", true},
+		{"github.com/Gorilla/Mux/v2", "gorilla/mux", true},
+		{"github.com/golang/sys/unix", "golang/sys", true},
+		{"github.com/gin-gonic", "", false},
+		{"golang.org/x/sys", "", false},
+	}
+
+	for _, test := range tests {
+		got, ok := GitHubRepo(test.path)
+		if got != test.want || ok != test.ok {
+			t.Errorf("GitHubRepo(%q) = %q, %v; want %q, %v", test.path, got, ok, test.want, test.ok)
+		}
+	}
+}
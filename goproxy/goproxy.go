@@ -0,0 +1,290 @@
+// Package goproxy is a client for the Go module proxy protocol
+// (https://golang.org/ref/mod#goproxy-protocol).
+package goproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DefaultURL is the public Go module proxy
+const DefaultURL = "https://proxy.golang.org"
+
+// Info is a version info returned by `@latest` and `@v/<version>.info`
+type Info struct {
+	Version string
+	Time    time.Time
+}
+
+// Client sends requests to a single module proxy. Both http(s):// and
+// file:// proxy URLs are supported.
+type Client struct {
+	httpClient *http.Client
+	proxyURL   *url.URL
+}
+
+// New returns a client for proxyURL. proxyURL may be a GOPROXY list,
+// the first http(s) or file URL in it is used.
+func New(proxyURL string) (*Client, error) {
+	for _, entry := range strings.FieldsFunc(proxyURL, func(r rune) bool { return r == ',' || r == '|' }) {
+		u, err := url.Parse(strings.TrimSpace(entry))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "file") {
+			continue
+		}
+
+		transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+		transport.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+
+		return &Client{
+			httpClient: &http.Client{Transport: transport, Timeout: time.Second * 30},
+			proxyURL:   u,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("No proxy URL in %q", proxyURL)
+}
+
+// List returns known versions of module sorted in ascending order.
+func (c *Client) List(path string) ([]string, error) {
+	body, err := c.get(path, "@v/list")
+	if err != nil {
+		return nil, err
+	}
+
+	versions := strings.Fields(body)
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) < 0
+	})
+
+	return versions, nil
+}
+
+// Latest returns latest version info of module.
+func (c *Client) Latest(path string) (Info, error) {
+	var info Info
+
+	body, err := c.get(path, "@latest")
+	if err != nil {
+		return info, err
+	}
+
+	err = json.Unmarshal([]byte(body), &info)
+	return info, err
+}
+
+// Mod returns go.mod file of module at version.
+func (c *Client) Mod(path, version string) (string, error) {
+	escaped, err := EscapeVersion(version)
+	if err != nil {
+		return "", err
+	}
+
+	return c.get(path, "@v/"+escaped+".mod")
+}
+
+// LatestVersion returns latest version of module. When proxy has no
+// `@latest` endpoint the highest release from `@v/list` is used, or the
+// highest pre-release if module has no releases, same as the go command.
+func (c *Client) LatestVersion(path string) (string, error) {
+	info, err := c.Latest(path)
+	if err == nil && info.Version != "" {
+		return info.Version, nil
+	}
+
+	versions, listErr := c.List(path)
+	if listErr != nil {
+		return "", listErr
+	}
+	if len(versions) == 0 {
+		return "", fmt.Errorf("No versions of %v", path)
+	}
+
+	for i := len(versions) - 1; i >= 0; i-- {
+		if _, pre := splitVersion(versions[i]); pre == "" {
+			return versions[i], nil
+		}
+	}
+
+	return versions[len(versions)-1], nil
+}
+
+// LatestMod returns latest version and go.mod file of module.
+func (c *Client) LatestMod(path string) (string, string, error) {
+	version, err := c.LatestVersion(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	mod, err := c.Mod(path, version)
+	return version, mod, err
+}
+
+func (c *Client) get(path, suffix string) (string, error) {
+	escaped, err := EscapePath(path)
+	if err != nil {
+		return "", err
+	}
+
+	u := *c.proxyURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + escaped + "/" + suffix
+
+	resp, err := c.httpClient.Get(u.String())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%v: %v %v", u.String(), resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return string(body), nil
+}
+
+// EscapePath escapes module path, every upper case letter is replaced
+// with an exclamation mark followed by the lower case letter.
+func EscapePath(path string) (string, error) {
+	if path == "" || strings.Contains(path, "..") || strings.HasPrefix(path, "/") {
+		return "", fmt.Errorf("Invalid module path %q", path)
+	}
+	return escape(path)
+}
+
+// EscapeVersion escapes version the same way as EscapePath.
+func EscapeVersion(version string) (string, error) {
+	if version == "" || strings.ContainsAny(version, "/\\") {
+		return "", fmt.Errorf("Invalid version %q", version)
+	}
+	return escape(version)
+}
+
+func escape(s string) (string, error) {
+	var b strings.Builder
+
+	for _, r := range s {
+		if r == '!' || r >= unicode.MaxASCII {
+			return "", fmt.Errorf("Invalid character %q in %q", r, s)
+		}
+		if 'A' <= r && r <= 'Z' {
+			b.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String(), nil
+}
+
+// compareVersions compares semantic versions `vMAJOR.MINOR.PATCH[-pre][+build]`.
+// Release versions are greater than pre-releases of the same version.
+func compareVersions(a, b string) int {
+	coreA, preA := splitVersion(a)
+	coreB, preB := splitVersion(b)
+
+	for i := 0; i < 3; i++ {
+		if coreA[i] != coreB[i] {
+			if coreA[i] < coreB[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case preA == preB:
+		return 0
+	case preA == "":
+		return 1
+	case preB == "":
+		return -1
+	}
+	return comparePrerelease(preA, preB)
+}
+
+// comparePrerelease compares dot separated pre-release identifiers as
+// semver §11 says: numeric identifiers are compared numerically and are
+// lower than alphanumeric ones, which are compared in ASCII order. A shorter
+// list of identifiers is lower if all preceding ones are equal.
+func comparePrerelease(a, b string) int {
+	partsA, partsB := strings.Split(a, "."), strings.Split(b, ".")
+
+	for i := 0; i < len(partsA) && i < len(partsB); i++ {
+		x, y := partsA[i], partsB[i]
+		if x == y {
+			continue
+		}
+
+		numX, numY := isNumeric(x), isNumeric(y)
+		switch {
+		case numX && numY:
+			// no leading zeros, longer number is greater
+			if len(x) != len(y) {
+				if len(x) < len(y) {
+					return -1
+				}
+				return 1
+			}
+		case numX:
+			return -1
+		case numY:
+			return 1
+		}
+
+		if x < y {
+			return -1
+		}
+		return 1
+	}
+
+	switch {
+	case len(partsA) < len(partsB):
+		return -1
+	case len(partsA) > len(partsB):
+		return 1
+	}
+	return 0
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func splitVersion(v string) ([3]int, string) {
+	var core [3]int
+
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.Index(v, "+"); i >= 0 {
+		v = v[:i]
+	}
+
+	pre := ""
+	if i := strings.Index(v, "-"); i >= 0 {
+		v, pre = v[:i], v[i+1:]
+	}
+
+	for i, part := range strings.SplitN(v, ".", 3) {
+		fmt.Sscanf(part, "%d", &core[i])
+	}
+
+	return core, pre
+}
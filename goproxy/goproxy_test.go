@@ -0,0 +1,157 @@
+package goproxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.0.0", "v1.0.0", 0},
+		{"v1.0.0", "v1.0.1", -1},
+		{"v1.10.0", "v1.9.0", 1},
+		{"v2.0.0", "v1.99.99", 1},
+		{"v1.0.0-rc.1", "v1.0.0", -1},
+		{"v1.0.0-rc.9", "v1.0.0-rc.10", -1},
+		{"v1.0.0-rc.10", "v1.0.0-rc.9", 1},
+		{"v1.0.0-alpha", "v1.0.0-alpha.1", -1},
+		{"v1.0.0-alpha.1", "v1.0.0-alpha.beta", -1},
+		{"v1.0.0-alpha.beta", "v1.0.0-beta", -1},
+		{"v1.0.0-beta.2", "v1.0.0-beta.11", -1},
+		{"v1.0.0-beta.11", "v1.0.0-rc.1", -1},
+		{"v1.0.0-1", "v1.0.0-alpha", -1},
+		{"v1.0.0+build.1", "v1.0.0+build.2", 0},
+		{"v0.0.0-20200101000000-abcdefabcdef", "v0.0.0-20200201000000-abcdefabcdef", -1},
+	}
+
+	for _, test := range tests {
+		if got := compareVersions(test.a, test.b); got != test.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+// newFileProxy returns a client of a file based proxy directory serving
+// github.com/BurntSushi/toml with @latest and golang.org/x/text, net and exp
+// without it.
+func newFileProxy(t *testing.T) (*Client, string) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "goproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"github.com/!burnt!sushi/toml/@v/list":       "v0.3.1\nv0.3.0\nv1.0.0-rc.10\nv1.0.0-rc.9\n",
+		"github.com/!burnt!sushi/toml/@latest":       `{"Version":"v0.3.1","Time":"2018-08-15T10:47:33Z"}`,
+		"github.com/!burnt!sushi/toml/@v/v0.3.1.mod": "module github.com/BurntSushi/toml\n",
+		"golang.org/x/text/@v/list":                  "v0.3.2\nv0.3.10\nv0.3.3\n",
+		"golang.org/x/text/@v/v0.3.10.mod":           "module golang.org/x/text\n\nrequire golang.org/x/tools v0.0.0-20180917221912-90fa682c2a6e\n",
+		"golang.org/x/text/@v/v0.3.2.mod":            "module golang.org/x/text\n",
+		"golang.org/x/net/@v/list":                   "v0.1.0\nv0.2.0-rc.1\nv0.0.9\n",
+		"golang.org/x/net/@v/v0.1.0.mod":             "module golang.org/x/net\n",
+		"golang.org/x/exp/@v/list":                   "v0.1.0-alpha\nv0.1.0-beta\n",
+		"golang.org/x/exp/@v/v0.1.0-beta.mod":        "module golang.org/x/exp\n",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := New("off,file://" + filepath.ToSlash(dir))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return c, dir
+}
+
+func TestFileProxyList(t *testing.T) {
+	c, dir := newFileProxy(t)
+	defer os.RemoveAll(dir)
+
+	versions, err := c.List("github.com/BurntSushi/toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"v0.3.0", "v0.3.1", "v1.0.0-rc.9", "v1.0.0-rc.10"}
+	if !reflect.DeepEqual(versions, want) {
+		t.Errorf("versions %v, want %v", versions, want)
+	}
+}
+
+func TestFileProxyLatestMod(t *testing.T) {
+	c, dir := newFileProxy(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		path    string
+		version string
+		mod     string
+	}{
+		// @latest is used when served
+		{"github.com/BurntSushi/toml", "v0.3.1", "module github.com/BurntSushi/toml\n"},
+		// the highest listed version otherwise
+		{"golang.org/x/text", "v0.3.10", "module golang.org/x/text\n\nrequire golang.org/x/tools v0.0.0-20180917221912-90fa682c2a6e\n"},
+		// the highest release rather than a higher pre-release
+		{"golang.org/x/net", "v0.1.0", "module golang.org/x/net\n"},
+		// the highest pre-release when there is no release
+		{"golang.org/x/exp", "v0.1.0-beta", "module golang.org/x/exp\n"},
+	}
+
+	for _, test := range tests {
+		version, mod, err := c.LatestMod(test.path)
+		if err != nil {
+			t.Errorf("%s: %v", test.path, err)
+			continue
+		}
+		if version != test.version || mod != test.mod {
+			t.Errorf("%s: %s %q, want %s %q", test.path, version, mod, test.version, test.mod)
+		}
+	}
+}
+
+func TestFileProxyMissingModule(t *testing.T) {
+	c, dir := newFileProxy(t)
+	defer os.RemoveAll(dir)
+
+	if _, _, err := c.LatestMod("gopkg.in/yaml.v2"); err == nil {
+		t.Errorf("missing module returned no error")
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	tests := []struct {
+		path, want string
+		ok         bool
+	}{
+		{"github.com/BurntSushi/toml", "github.com/!burnt!sushi/toml", true},
+		{"golang.org/x/text", "golang.org/x/text", true},
+		{"", "", false},
+		{"/etc/passwd", "", false},
+		{"github.com/../../etc", "", false},
+		{"github.com/a!b", "", false},
+	}
+
+	for _, test := range tests {
+		got, err := EscapePath(test.path)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("EscapePath(%q) = %q, %v; want %q, ok %v", test.path, got, err, test.want, test.ok)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -31,25 +32,39 @@ var (
 	c = cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
 	})
+
+	memoryFile = flag.String("memory", "", "serve repos loaded from JSON `file` of items kept in memory instead of PostgreSQL")
 )
 
 func main() {
 
-	utils.CheckEnvVars(true, true, false)
+	flag.Parse()
+
+	if *memoryFile != "" {
+		memory, err := database.LoadMemory(*memoryFile)
+		utils.HandleErrEXIT(err, "MEMORY STORAGE")
+		database.Store = memory
+	} else {
+		utils.CheckEnvVars(true, true, false, false)
+		utils.HandleErrEXIT(database.CheckMigrations(), "DB MIGRATIONS")
+	}
 
 	router := mux.NewRouter()
 
-	router.HandleFunc("/page/", page)     // /page/?page=<page>
-	router.HandleFunc("/module/", module) // /module/?name=<name> or /module/?id=<id>
+	router.HandleFunc("/page/", page)             // /page/?page=<page>
+	router.HandleFunc("/module/", module)         // /module/?name=<name> or /module/?id=<id>
+	router.HandleFunc("/dependents/", dependents) // /dependents/?id=<id>&depth=<depth>
+	router.HandleFunc("/history/", history)       // /history/?id=<id>&from=<from>&to=<to>
+	router.HandleFunc("/trending/", trending)     // /trending/?window=<window>&limit=<limit>
 
-	router.HandleFunc("/search/", search) // /search/?search=<term>
+	router.HandleFunc("/search/", search) // /search/?search=<term>&page=<page>&limit=<limit>
 	router.HandleFunc("/multi/", multi)   // /multi/?ids=1,2,3,4,5
 	router.HandleFunc("/readme/", readme)
 	http.Handle("/", router)
 
 	var servers []*http.Server
 	for i := 0; i < 4; i++ {
-		addr := fmt.Sprintf("127.0.0.1:300" + utils.IntToStr(i))
+		addr := "127.0.0.1:300" + utils.IntToStr(i)
 		srv := &http.Server{
 			Addr:         addr,
 			WriteTimeout: time.Second * 15,
@@ -113,10 +128,16 @@ func module(w http.ResponseWriter, r *http.Request) {
 
 	if name != "" {
 		result := database.SelectALLByName(name)
+		if result != "" {
+			w.WriteHeader(http.StatusOK)
+			_, err := fmt.Fprint(w, result)
+			utils.HandleErrLog(err, "MODULE FUNC: OK - with name param")
+			return
+		}
 
-		w.WriteHeader(http.StatusOK)
-		_, err := fmt.Fprintf(w, result)
-		utils.HandleErrLog(err, "MODULE FUNC: OK - with name param")
+		w.WriteHeader(http.StatusNotFound)
+		_, err := fmt.Fprintf(w, "Not Found")
+		utils.HandleErrLog(err, "MODULE FUNC: NOT FOUND - with name param")
 		return
 	}
 
@@ -124,23 +145,10 @@ func module(w http.ResponseWriter, r *http.Request) {
 		depthLevel := r.URL.Query().Get("depth")
 
 		if depthLevel != "" {
-			key := fmt.Sprintf("%s-%s", id, depthLevel)
-
-			var byteResult []byte
-			var result string
-			byteResult, redisErr := redisClient.Get(key).Bytes()
-
-			if redisErr != nil {
-				result = database.SelectByIDWithModules(id, depthLevel)
-			} else {
-				var buf bytes.Buffer
-				utils.Ungzip(&buf, byteResult)
-				result = buf.String()
-			}
-			// result := database.SelectByIDWithModules(id, depthLevel)
+			result := selectModules(id, depthLevel)
 			if result != "" {
 				w.WriteHeader(http.StatusOK)
-				_, err := fmt.Fprintf(w, result)
+				_, err := fmt.Fprint(w, result)
 				utils.HandleErrLog(err, "MODULE FUNC: OK - with depth")
 				return
 			}
@@ -154,7 +162,7 @@ func module(w http.ResponseWriter, r *http.Request) {
 		result := database.SelectByID(id)
 		if result != "" {
 			w.WriteHeader(http.StatusOK)
-			_, err := fmt.Fprintf(w, result)
+			_, err := fmt.Fprint(w, result)
 			utils.HandleErrLog(err, "MODULE FUNC: OK - select by ID")
 			return
 		}
@@ -171,14 +179,119 @@ func module(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// selectModules selects repo with id and its modules down to depthLevel.
+// Results selected from PostgreSQL are cached gzipped in Redis for 30 minutes,
+// in memory storage is not cached.
+func selectModules(id, depthLevel string) string {
+	if _, ok := database.Store.(*database.Postgres); !ok {
+		return database.SelectByIDWithModules(id, depthLevel)
+	}
+
+	key := fmt.Sprintf("%s-%s", id, depthLevel)
+
+	byteResult, redisErr := redisClient.Get(key).Bytes()
+	if redisErr == nil {
+		var buf bytes.Buffer
+		utils.Ungzip(&buf, byteResult)
+		return buf.String()
+	}
+
+	result := database.SelectByIDWithModules(id, depthLevel)
+	if result == "" {
+		return result
+	}
+
+	/** takes too much RAM space **/
+	var buf bytes.Buffer
+	gzipErr := utils.Gzip(&buf, []byte(result))
+	if gzipErr != nil {
+		log.Println(gzipErr)
+		return result
+	}
+
+	redisClient.Set(key, buf.Bytes(), time.Minute*30).Result()
+
+	return result
+}
+
+func dependents(w http.ResponseWriter, r *http.Request) {
+	enableCors(&w)
+
+	id := r.URL.Query().Get("id")
+	depthLevel, levelErr := utils.CheckLevel(r.URL.Query().Get("depth"))
+
+	if id == "" || levelErr != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := fmt.Fprintf(w, "'id' parameter and valid 'depth' required. Example URL /dependents/?id=<id>&depth=<depth>")
+		utils.HandleErrLog(err, "DEPENDENTS FUNC: BAD REQUEST")
+		return
+	}
+
+	result := database.SelectDependents(id, depthLevel)
+	if result != "" {
+		w.WriteHeader(http.StatusOK)
+		_, err := fmt.Fprint(w, result)
+		utils.HandleErrLog(err, "DEPENDENTS FUNC: OK")
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+	_, err := fmt.Fprintf(w, "Not Found")
+	utils.HandleErrLog(err, "DEPENDENTS FUNC: NOT FOUND - with id param")
+}
+
+func history(w http.ResponseWriter, r *http.Request) {
+	enableCors(&w)
+
+	id := r.URL.Query().Get("id")
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+
+	if id != "" {
+		result := database.SelectHistory(id, from, to)
+		if result != "" {
+			w.WriteHeader(http.StatusOK)
+			_, err := fmt.Fprint(w, result)
+			utils.HandleErrLog(err, "HISTORY FUNC: OK")
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+	_, err := fmt.Fprintf(w, "'id' parameter and valid 'from', 'to' dates required. Example URL /history/?id=<id>&from=2019-01-01&to=2019-12-31")
+	utils.HandleErrLog(err, "HISTORY FUNC: NOT FOUND")
+}
+
+func trending(w http.ResponseWriter, r *http.Request) {
+	enableCors(&w)
+
+	window := r.URL.Query().Get("window")
+	limit := r.URL.Query().Get("limit")
+
+	result := database.SelectTrending(window, limit)
+	if result != "" {
+		w.WriteHeader(http.StatusOK)
+		_, err := fmt.Fprint(w, result)
+		utils.HandleErrLog(err, "TRENDING FUNC: OK")
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+	_, err := fmt.Fprintf(w, "Invalid 'window' or 'limit' parameter. Example URL /trending/?window=7d&limit=10")
+	utils.HandleErrLog(err, "TRENDING FUNC: NOT FOUND")
+}
+
 func search(w http.ResponseWriter, r *http.Request) {
 	enableCors(&w)
 
 	term := r.URL.Query().Get("search")
+	page := r.URL.Query().Get("page")
+	limit := r.URL.Query().Get("limit")
+
 	if term != "" {
-		repo := database.Search(term)
+		repo := database.Search(term, page, limit)
 		w.WriteHeader(http.StatusOK)
-		_, err := fmt.Fprintf(w, string(repo))
+		_, err := fmt.Fprint(w, string(repo))
 		utils.HandleErrLog(err, "SEARCH FUNC: OK")
 		return
 	}
@@ -196,7 +309,7 @@ func multi(w http.ResponseWriter, r *http.Request) {
 		resp := database.SelectMultipleByID(ids)
 
 		w.WriteHeader(http.StatusOK)
-		_, err := fmt.Fprintf(w, resp)
+		_, err := fmt.Fprint(w, resp)
 		utils.HandleErrLog(err, "MULTI FUNC: OK")
 		return
 	}
@@ -210,10 +323,16 @@ func readme(w http.ResponseWriter, r *http.Request) {
 	enableCors(&w)
 	id := r.URL.Query().Get("id")
 	if id != "" {
-		resp := database.SelectReadme(id)
+		resp, err := database.SelectReadme(id)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			_, err := fmt.Fprintf(w, "Not Found")
+			utils.HandleErrLog(err, "README FUNC: NOT FOUND - with id param")
+			return
+		}
 
 		w.WriteHeader(http.StatusOK)
-		err := json.NewEncoder(w).Encode(resp)
+		err = json.NewEncoder(w).Encode(resp)
 		utils.HandleErrLog(err, "README FUNC: JSON ENCODE")
 		return
 	}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	database "github.com/a-sube/go-repos-api/db"
+	"github.com/a-sube/go-repos-api/structs"
+)
+
+// TestMain serves repos of testdata/repos.json from memory, so handlers
+// run without PostgreSQL and Redis. Ids are assigned in order of insertion:
+// 1 gin-gonic/gin, 2 ugorji/go, 3 mattn/go-isatty, 4 labstack/echo,
+// 5 valyala/fasttemplate, 6 golang/sys, 7 gorilla/mux.
+func TestMain(m *testing.M) {
+	memory, err := database.LoadMemory("testdata/repos.json")
+	if err != nil {
+		panic(err)
+	}
+	database.Store = memory
+
+	os.Exit(m.Run())
+}
+
+func get(handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", target, nil))
+	return w
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("invalid json %q: %v", w.Body.String(), err)
+	}
+}
+
+func fullNames(repos []database.Repo) []string {
+	names := []string{}
+	for _, repo := range repos {
+		names = append(names, repo.FullName)
+	}
+	return names
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestModuleByID(t *testing.T) {
+	w := get(module, "/module/?id=1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+
+	var repo database.Repo
+	decode(t, w, &repo)
+
+	if repo.FullName != "gin-gonic/gin" {
+		t.Errorf("full name %q, want gin-gonic/gin", repo.FullName)
+	}
+}
+
+func TestModuleWithDepth(t *testing.T) {
+	w := get(module, "/module/?id=1&depth=2")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+
+	var repo database.Repo
+	decode(t, w, &repo)
+
+	if got, want := fullNames(repo.Modules), []string{"mattn/go-isatty", "ugorji/go"}; !equal(got, want) {
+		t.Fatalf("modules %v, want %v", got, want)
+	}
+
+	isatty := repo.Modules[0]
+	if isatty.Edge == nil || isatty.Edge.Version != "v0.0.12" {
+		t.Errorf("edge %+v, want version v0.0.12", isatty.Edge)
+	}
+	if got, want := fullNames(isatty.Modules), []string{"golang/sys"}; !equal(got, want) {
+		t.Errorf("modules of go-isatty %v, want %v", got, want)
+	}
+}
+
+func TestModuleByName(t *testing.T) {
+	w := get(module, "/module/?name=echo")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+
+	var resp database.DBResponse
+	decode(t, w, &resp)
+
+	if got, want := fullNames(resp.Items), []string{"labstack/echo"}; !equal(got, want) {
+		t.Errorf("items %v, want %v", got, want)
+	}
+}
+
+func TestPage(t *testing.T) {
+	w := get(page, "/page/?page=1&limit=2")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+
+	var repos []database.Repo
+	decode(t, w, &repos)
+
+	if got, want := fullNames(repos), []string{"gin-gonic/gin", "golang/sys"}; !equal(got, want) {
+		t.Errorf("page %v, want %v", got, want)
+	}
+}
+
+func TestDependents(t *testing.T) {
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{"/dependents/?id=3&depth=1", []string{"gin-gonic/gin", "labstack/echo"}},
+		{"/dependents/?id=6&depth=1", []string{"mattn/go-isatty"}},
+		{"/dependents/?id=6&depth=2", []string{"gin-gonic/gin", "labstack/echo", "mattn/go-isatty"}},
+	}
+
+	for _, test := range tests {
+		w := get(dependents, test.url)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d, want 200", test.url, w.Code)
+			continue
+		}
+
+		var resp database.DBResponse
+		decode(t, w, &resp)
+
+		if got := fullNames(resp.Items); !equal(got, test.want) {
+			t.Errorf("%s: dependents %v, want %v", test.url, got, test.want)
+		}
+	}
+}
+
+func TestDependentsInvalid(t *testing.T) {
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{"/dependents/?id=99&depth=1", http.StatusNotFound},
+		{"/dependents/?id=abc&depth=1", http.StatusNotFound},
+		{"/dependents/?id=3&depth=0", http.StatusBadRequest},
+		{"/dependents/?id=3&depth=-1", http.StatusBadRequest},
+		{"/dependents/?id=3&depth=abc", http.StatusBadRequest},
+		{"/dependents/?depth=1", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		if w := get(dependents, test.url); w.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.url, w.Code, test.status)
+		}
+	}
+}
+
+func TestDependentsNone(t *testing.T) {
+	w := get(dependents, "/dependents/?id=1&depth=1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+
+	var resp database.DBResponse
+	decode(t, w, &resp)
+
+	if resp.Count != 0 {
+		t.Errorf("%d dependents of gin-gonic/gin, want 0", resp.Count)
+	}
+}
+
+func TestReadme(t *testing.T) {
+	w := get(readme, "/readme/?id=4")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+
+	var readme string
+	decode(t, w, &readme)
+
+	if readme != "<h1>Echo</h1>" {
+		t.Errorf("readme %q, want <h1>Echo</h1>", readme)
+	}
+}
+
+func TestMulti(t *testing.T) {
+	w := get(multi, "/multi/?ids=4,1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+
+	var resp database.DBResponse
+	decode(t, w, &resp)
+
+	if got, want := fullNames(resp.Items), []string{"labstack/echo", "gin-gonic/gin"}; !equal(got, want) {
+		t.Errorf("items %v, want %v", got, want)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	w := get(search, "/search/?search=web+frame")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+
+	var resp database.DBResponse
+	decode(t, w, &resp)
+
+	if got, want := fullNames(resp.Items), []string{"gin-gonic/gin", "labstack/echo"}; !equal(got, want) {
+		t.Errorf("items %v, want %v", got, want)
+	}
+}
+
+func TestSearchSuggestion(t *testing.T) {
+	tests := []struct {
+		url        string
+		suggestion string
+		want       []string
+	}{
+		// nothing matches, repos with similar names are returned
+		{"/search/?search=gorila+mux", "gorilla/mux", []string{"gorilla/mux"}},
+		// past the first page nothing matching is not a misspelling
+		{"/search/?search=gorila+mux&page=2", "", []string{}},
+		// term matches, no suggestion
+		{"/search/?search=mux", "", []string{"gorilla/mux"}},
+		// nothing similar
+		{"/search/?search=kubernetes", "", []string{}},
+	}
+
+	for _, test := range tests {
+		w := get(search, test.url)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d, want 200", test.url, w.Code)
+			continue
+		}
+
+		var resp database.DBResponse
+		decode(t, w, &resp)
+
+		if resp.Suggestion != test.suggestion {
+			t.Errorf("%s: suggestion %q, want %q", test.url, resp.Suggestion, test.suggestion)
+		}
+		if got := fullNames(resp.Items); !equal(got, test.want) {
+			t.Errorf("%s: items %v, want %v", test.url, got, test.want)
+		}
+		if resp.Total != len(test.want) {
+			t.Errorf("%s: total %d, want %d", test.url, resp.Total, len(test.want))
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	w := get(history, "/history/?id=1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+
+	var resp database.HistoryResponse
+	decode(t, w, &resp)
+
+	if resp.Count != 1 || resp.Snapshots[0].StargazersCount != 500 {
+		t.Errorf("history %+v, want a single snapshot of 500 stars", resp)
+	}
+}
+
+// injectionIDs are ids which must be rejected before reaching the storage
+// or not found by it
+var injectionIDs = []string{
+	"1 OR 1=1",
+	"1;DROP TABLE repos",
+	"1' OR '1'='1",
+	"1--",
+	"-1",
+	"0",
+	"1e3",
+	"0x1",
+	"abc",
+	"99999999999999999999",
+	// valid ids past the end of the table
+	"8",
+	"2147483648",
+}
+
+func TestModuleInjection(t *testing.T) {
+	for _, id := range injectionIDs {
+		for _, depth := range []string{"", "&depth=2"} {
+			target := "/module/?id=" + url.QueryEscape(id) + depth
+
+			w := get(module, target)
+			if w.Code != http.StatusNotFound {
+				t.Errorf("%s: status %d, want 404", target, w.Code)
+			}
+		}
+	}
+
+	assertReposIntact(t)
+}
+
+func TestReadmeInjection(t *testing.T) {
+	for _, id := range injectionIDs {
+		target := "/readme/?id=" + url.QueryEscape(id)
+
+		w := get(readme, target)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", target, w.Code)
+		}
+	}
+
+	assertReposIntact(t)
+}
+
+func TestMultiInjection(t *testing.T) {
+	tests := []struct {
+		ids  string
+		want []string
+	}{
+		{"1,abc,2", []string{"gin-gonic/gin", "ugorji/go"}},
+		{"1 OR 1=1", []string{}},
+		{"1;DROP TABLE repos,4", []string{"labstack/echo"}},
+		{"-1,0,1e3", []string{}},
+		{"4,,1", []string{"labstack/echo", "gin-gonic/gin"}},
+	}
+
+	for _, test := range tests {
+		target := "/multi/?ids=" + url.QueryEscape(test.ids)
+
+		w := get(multi, target)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d, want 200", target, w.Code)
+			continue
+		}
+
+		var resp database.DBResponse
+		decode(t, w, &resp)
+
+		if got := fullNames(resp.Items); !equal(got, test.want) {
+			t.Errorf("%s: items %v, want %v", target, got, test.want)
+		}
+	}
+
+	assertReposIntact(t)
+}
+
+// assertReposIntact checks all repos are still served
+func assertReposIntact(t *testing.T) {
+	t.Helper()
+
+	var repos []database.Repo
+	decode(t, get(page, "/page/?page=1&limit=10"), &repos)
+
+	if len(repos) != 7 {
+		t.Errorf("%d repos served, want 7", len(repos))
+	}
+}
+
+func TestTrending(t *testing.T) {
+	// separate storage, growth needs repos inserted twice
+	defer func(store database.Storage) { database.Store = store }(database.Store)
+	memory := database.NewMemory()
+	database.Store = memory
+
+	for _, stars := range [][3]int{{10, 100, 5}, {25, 110, 5}} {
+		for i, name := range []string{"fast", "slow", "flat"} {
+			item := structs.Item{Name: name, FullName: "trend/" + name, StargazersCount: stars[i]}
+			if err := memory.Insert(item); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{"/trending/", []string{"trend/fast", "trend/slow"}},
+		{"/trending/?window=24h", []string{"trend/fast", "trend/slow"}},
+		{"/trending/?window=30d&limit=1", []string{"trend/fast"}},
+		{"/trending/?limit=1000", []string{"trend/fast", "trend/slow"}},
+	}
+
+	for _, test := range tests {
+		w := get(trending, test.url)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d, want 200", test.url, w.Code)
+			continue
+		}
+
+		var resp database.DBResponse
+		decode(t, w, &resp)
+
+		if got := fullNames(resp.Items); !equal(got, test.want) {
+			t.Errorf("%s: items %v, want %v", test.url, got, test.want)
+		}
+		if resp.Count != len(test.want) {
+			t.Errorf("%s: count %d, want %d", test.url, resp.Count, len(test.want))
+		}
+		if len(resp.Items) > 0 && resp.Items[0].StarGrowth != 15 {
+			t.Errorf("%s: star growth %d, want 15", test.url, resp.Items[0].StarGrowth)
+		}
+	}
+}
+
+func TestTrendingInvalid(t *testing.T) {
+	for _, query := range []string{"window=0d", "window=-1h", "window=week", "window=1.5d", "limit=0", "limit=-1", "limit=ten"} {
+		w := get(trending, "/trending/?"+query)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", query, w.Code)
+		}
+	}
+}
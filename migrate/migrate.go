@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	database "github.com/a-sube/go-repos-api/db"
+	"github.com/a-sube/go-repos-api/utils"
+)
+
+const usage = `usage: migrate <command>
+
+commands:
+	up      apply all pending migrations
+	down    revert the last applied migration
+	status  list migrations and time they were applied
+`
+
+func main() {
+
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	utils.CheckEnvVars(true, true, false, false)
+
+	switch flag.Arg(0) {
+	case "up":
+		count, err := database.MigrateUp()
+		utils.HandleErrEXIT(err, "MIGRATE UP")
+		log.Printf("APPLIED %d MIGRATIONS\n", count)
+
+	case "down":
+		m, ok, err := database.MigrateDown()
+		utils.HandleErrEXIT(err, "MIGRATE DOWN")
+		if !ok {
+			log.Println("NO MIGRATIONS APPLIED")
+			return
+		}
+		log.Printf("REVERTED MIGRATION %d %s\n", m.Version, m.Name)
+
+	case "status":
+		states, err := database.MigrationStatus()
+		utils.HandleErrEXIT(err, "MIGRATE STATUS")
+		for _, state := range states {
+			applied := "pending"
+			if state.Applied() {
+				applied = state.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if state.Unknown {
+				applied += " (unknown to this binary)"
+			}
+			fmt.Printf("%4d  %-30s %s\n", state.Version, state.Name, applied)
+		}
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
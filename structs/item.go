@@ -53,6 +53,22 @@ type Item struct {
 	Readme          string  `json:"readme"`
 	Modules         []*Item `json:"modules"`
 	ReadmeIsSet     bool
+	// Version, Indirect and Replace describe requirement of this item in
+	// the parent's go.mod
+	Version  string `json:"version"`
+	Indirect bool   `json:"indirect"`
+	Replace  string `json:"replace"`
+	// ModulePath is set for modules not hosted on github and resolved
+	// through the module proxy. FullName is lower cased ModulePath.
+	ModulePath string `json:"module_path"`
+	// GoMod is a raw go.mod prefetched along with metadata
+	GoMod      string `json:"go_mod"`
+	GoModIsSet bool   `json:"go_mod_is_set"`
+	// ModulesComplete is set if Modules are all requirements of the latest
+	// go.mod, so edges to modules not listed are stale
+	ModulesComplete bool `json:"modules_complete"`
+	// InsertFailures is a count of times queued item failed to be stored
+	InsertFailures int `json:"insert_failures,omitempty"`
 }
 
 // StoreToRedis stores received repos to redis
@@ -82,3 +98,8 @@ func (item *Item) SetReadme(readme string) {
 	item.Readme = readme
 	item.ReadmeIsSet = true
 }
+
+func (item *Item) SetGoMod(gomod string) {
+	item.GoMod = gomod
+	item.GoModIsSet = true
+}
@@ -36,7 +36,7 @@ func CheckLevel(level string) (string, error) {
 	}
 
 	rLevel, lErr := StrToInt(level)
-	if lErr != nil {
+	if lErr != nil || rLevel < 1 {
 		return "", fmt.Errorf("Invalid level")
 	}
 
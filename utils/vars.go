@@ -3,6 +3,7 @@ package utils
 import (
 	"log"
 	"os"
+	"strings"
 )
 
 var (
@@ -12,10 +13,26 @@ var (
 	DBPSWD, pswdOK = os.LookupEnv("DBPASSWORD")
 	// ACCESSTOKEN is github access token
 	ACCESSTOKEN, accessTokenOk = os.LookupEnv("GITHUB_ACCESS_TOKEN")
+	// GITHUBURL is an optional github api base url, default is api.github.com
+	GITHUBURL, githubURLOk = os.LookupEnv("GITHUB_API_URL")
+	// GOPROXY is an optional go module proxy url, default is proxy.golang.org
+	GOPROXY, goproxyOk = os.LookupEnv("GOPROXY")
 	// ORIGIN is a request origin
 	ORIGIN, originOK = os.LookupEnv("ORIGIN") // depends
 )
 
+// AccessTokens returns github access tokens. GITHUB_ACCESS_TOKEN may hold
+// several comma separated tokens.
+func AccessTokens() []string {
+	tokens := []string{}
+	for _, token := range strings.Split(ACCESSTOKEN, ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
 // CheckEnvVars checks if ENV vars are set. If not exits process.
 func CheckEnvVars(user, pswd, accessToken, origin bool) {
 
@@ -0,0 +1,206 @@
+// Package vanity resolves vanity import paths (go.uber.org/zap, k8s.io/client-go)
+// to their source repositories using `<meta name="go-import">` tags served
+// at `https://<import path>?go-get=1`.
+package vanity
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Import is a single go-import meta tag: `<prefix> <vcs> <repo root>`
+type Import struct {
+	Prefix   string
+	VCS      string
+	RepoRoot string
+}
+
+// GitHubRepo returns `owner/repo` if repo root is hosted on github.com
+func (imp Import) GitHubRepo() (string, bool) {
+	root := strings.TrimSuffix(imp.RepoRoot, "/")
+	root = strings.TrimSuffix(root, ".git")
+
+	for _, prefix := range []string{"https://github.com/", "http://github.com/", "git://github.com/", "git@github.com:"} {
+		if !strings.HasPrefix(root, prefix) {
+			continue
+		}
+
+		parts := strings.Split(strings.TrimPrefix(root, prefix), "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return "", false
+		}
+		return strings.ToLower(parts[0] + "/" + parts[1]), true
+	}
+
+	return "", false
+}
+
+// NegativeTTL is how long a failed resolution is cached. Failures are often
+// temporary (timeouts, 5xx), so they are retried after a short time while
+// resolved imports are cached for the lifetime of the resolver.
+const NegativeTTL = time.Minute * 10
+
+type result struct {
+	imp Import
+	err error
+	// expires is set for failures only
+	expires time.Time
+}
+
+// Resolver resolves and caches go-import meta tags
+type Resolver struct {
+	httpClient *http.Client
+	now        func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]result
+}
+
+// NewResolver returns a resolver sending requests over https with transport.
+// If transport is nil http.DefaultTransport is used.
+func NewResolver(transport http.RoundTripper) *Resolver {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	return &Resolver{
+		httpClient: &http.Client{Transport: transport, Timeout: time.Second * 15},
+		now:        time.Now,
+		cache:      make(map[string]result),
+	}
+}
+
+// Resolve returns go-import tag matching import path. Resolved imports are
+// cached for the lifetime of the resolver, failures for NegativeTTL.
+func (r *Resolver) Resolve(path string) (Import, error) {
+	r.mu.Lock()
+	cached, ok := r.cache[path]
+	r.mu.Unlock()
+
+	if ok && (cached.err == nil || r.now().Before(cached.expires)) {
+		return cached.imp, cached.err
+	}
+
+	imp, err := r.fetch(path)
+
+	entry := result{imp: imp, err: err}
+	if err != nil {
+		entry.expires = r.now().Add(NegativeTTL)
+	}
+
+	r.mu.Lock()
+	r.cache[path] = entry
+	r.mu.Unlock()
+
+	return imp, err
+}
+
+func (r *Resolver) fetch(path string) (Import, error) {
+	if path == "" || strings.ContainsAny(path, "?#@ ") || !strings.Contains(strings.Split(path, "/")[0], ".") {
+		return Import{}, fmt.Errorf("Invalid import path %q", path)
+	}
+
+	resp, err := r.httpClient.Get("https://" + path + "?go-get=1")
+	if err != nil {
+		return Import{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Import{}, fmt.Errorf("%v?go-get=1: %v", path, resp.Status)
+	}
+
+	imports, err := ParseMetaImports(resp.Body)
+	if err != nil {
+		return Import{}, err
+	}
+
+	return MatchImport(path, imports)
+}
+
+// MatchImport returns the import with the longest prefix matching path.
+// `mod` imports are used only if no other vcs is declared for the prefix.
+func MatchImport(path string, imports []Import) (Import, error) {
+	var match *Import
+
+	for i := range imports {
+		imp := &imports[i]
+		if imp.Prefix != path && !strings.HasPrefix(path, imp.Prefix+"/") {
+			continue
+		}
+
+		switch {
+		case match == nil || len(imp.Prefix) > len(match.Prefix):
+			match = imp
+		case len(imp.Prefix) == len(match.Prefix) && match.VCS == "mod":
+			match = imp
+		}
+	}
+
+	if match == nil {
+		return Import{}, fmt.Errorf("No go-import tag for %v", path)
+	}
+
+	return *match, nil
+}
+
+// ParseMetaImports reads go-import meta tags from html. Reading stops at
+// the end of `<head>`, same as the go command does.
+func ParseMetaImports(r io.Reader) ([]Import, error) {
+	d := xml.NewDecoder(r)
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		if strings.EqualFold(charset, "utf-8") || strings.EqualFold(charset, "ascii") {
+			return input, nil
+		}
+		return nil, fmt.Errorf("Can't decode XML document using charset %q", charset)
+	}
+	d.Strict = false
+
+	imports := []Import{}
+
+	for {
+		t, err := d.RawToken()
+		if err != nil {
+			if err == io.EOF || len(imports) > 0 {
+				break
+			}
+			return nil, err
+		}
+
+		if e, ok := t.(xml.StartElement); ok && strings.EqualFold(e.Name.Local, "body") {
+			break
+		}
+		if e, ok := t.(xml.EndElement); ok && strings.EqualFold(e.Name.Local, "head") {
+			break
+		}
+
+		e, ok := t.(xml.StartElement)
+		if !ok || !strings.EqualFold(e.Name.Local, "meta") || attrValue(e.Attr, "name") != "go-import" {
+			continue
+		}
+
+		if fields := strings.Fields(attrValue(e.Attr, "content")); len(fields) == 3 {
+			imports = append(imports, Import{
+				Prefix:   fields[0],
+				VCS:      fields[1],
+				RepoRoot: fields[2],
+			})
+		}
+	}
+
+	return imports, nil
+}
+
+func attrValue(attrs []xml.Attr, name string) string {
+	for _, a := range attrs {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,219 @@
+package vanity
+
+import (
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// transportFunc serves requests of resolver without network
+type transportFunc func(req *http.Request) (*http.Response, error)
+
+func (f transportFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+const zapHTML = `<html><head>
+<meta name="go-import" content="go.uber.org/zap git https://github.com/uber-go/zap">
+</head><body></body></html>`
+
+func TestResolveRetriesFailure(t *testing.T) {
+	requests := 0
+	status := http.StatusServiceUnavailable
+
+	r := NewResolver(transportFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return &http.Response{
+			StatusCode: status,
+			Status:     http.StatusText(status),
+			Body:       ioutil.NopCloser(strings.NewReader(zapHTML)),
+			Request:    req,
+		}, nil
+	}))
+
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	if _, err := r.Resolve("go.uber.org/zap"); err == nil {
+		t.Fatalf("503 resolved")
+	}
+
+	// failure is cached until NegativeTTL passes
+	status = http.StatusOK
+	now = now.Add(NegativeTTL - time.Second)
+	if _, err := r.Resolve("go.uber.org/zap"); err == nil || requests != 1 {
+		t.Fatalf("error %v after %d requests, want cached failure after 1 request", err, requests)
+	}
+
+	now = now.Add(time.Second)
+	imp, err := r.Resolve("go.uber.org/zap")
+	if err != nil || requests != 2 {
+		t.Fatalf("error %v after %d requests, want resolved after 2 requests", err, requests)
+	}
+	if repo, ok := imp.GitHubRepo(); !ok || repo != "uber-go/zap" {
+		t.Errorf("repo %q, want uber-go/zap", repo)
+	}
+
+	// resolved import never expires
+	now = now.Add(NegativeTTL * 100)
+	if _, err := r.Resolve("go.uber.org/zap"); err != nil || requests != 2 {
+		t.Errorf("error %v after %d requests, want cached import after 2 requests", err, requests)
+	}
+}
+
+func TestParseMetaImports(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want []Import
+		ok   bool
+	}{
+		{
+			name: "several tags",
+			html: `<!DOCTYPE html><html><head>
+<meta charset="utf-8">
+<meta name="go-import" content="k8s.io/client-go git https://github.com/kubernetes/client-go">
+<META NAME="go-import" CONTENT="k8s.io/api   git   https://github.com/kubernetes/api">
+<meta name="go-import" content='k8s.io/api mod https://proxy.golang.org'>
+<meta name="go-source" content="k8s.io/api https://github.com/kubernetes/api _ _">
+</head></html>`,
+			want: []Import{
+				{"k8s.io/client-go", "git", "https://github.com/kubernetes/client-go"},
+				{"k8s.io/api", "git", "https://github.com/kubernetes/api"},
+				{"k8s.io/api", "mod", "https://proxy.golang.org"},
+			},
+			ok: true,
+		},
+		{
+			name: "tags after head ignored",
+			html: `<html><head><meta name="go-import" content="a.io/x git https://github.com/a/x"></head>
+<meta name="go-import" content="a.io/y git https://github.com/a/y"></html>`,
+			want: []Import{{"a.io/x", "git", "https://github.com/a/x"}},
+			ok:   true,
+		},
+		{
+			name: "tags in body ignored",
+			html: `<html><body><meta name="go-import" content="a.io/x git https://github.com/a/x"></body></html>`,
+			want: []Import{},
+			ok:   true,
+		},
+		{
+			name: "malformed content ignored",
+			html: `<html><head>
+<meta name="go-import" content="a.io/x git">
+<meta name="go-import" content="a.io/x git https://github.com/a/x extra">
+<meta name="go-import" content="">
+<meta name="go-import">
+<meta name="go-import" content="a.io/y git https://github.com/a/y">
+</head></html>`,
+			want: []Import{{"a.io/y", "git", "https://github.com/a/y"}},
+			ok:   true,
+		},
+		{
+			name: "no tags",
+			html: `not html at all`,
+			want: []Import{},
+			ok:   true,
+		},
+		{
+			name: "broken html after tags",
+			html: `<html><head><meta name="go-import" content="a.io/x git https://github.com/a/x"><meta <<< &&& </`,
+			want: []Import{{"a.io/x", "git", "https://github.com/a/x"}},
+			ok:   true,
+		},
+		{
+			name: "broken html before tags",
+			html: `<html><head><meta name="go-import" content="a.io/x git`,
+		},
+		{
+			name: "unsupported charset",
+			html: `<?xml version="1.0" encoding="latin1"?><html><head><meta name="go-import" content="a.io/x git https://github.com/a/x"></head></html>`,
+		},
+	}
+
+	for _, test := range tests {
+		got, err := ParseMetaImports(strings.NewReader(test.html))
+		if (err == nil) != test.ok {
+			t.Errorf("%s: error %v, want ok %v", test.name, err, test.ok)
+			continue
+		}
+		if test.ok && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: imports %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMatchImport(t *testing.T) {
+	imports := []Import{
+		{"example.com/foo", "git", "https://github.com/example/foo"},
+		{"example.com/foo/v2", "git", "https://github.com/example/foo-v2"},
+		{"example.com/mod", "mod", "https://proxy.example.com"},
+		{"example.com/bar", "mod", "https://proxy.example.com"},
+		{"example.com/bar", "git", "https://github.com/example/bar"},
+		{"example.com/baz", "git", "https://github.com/example/baz"},
+		{"example.com/baz", "mod", "https://proxy.example.com"},
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"example.com/foo", "https://github.com/example/foo"},
+		{"example.com/foo/sub/pkg", "https://github.com/example/foo"},
+		// longest prefix wins
+		{"example.com/foo/v2", "https://github.com/example/foo-v2"},
+		{"example.com/foo/v2/sub", "https://github.com/example/foo-v2"},
+		// prefixes match whole path segments only
+		{"example.com/foobar", ""},
+		{"example.com/foo/v22", "https://github.com/example/foo"},
+		{"example.com", ""},
+		// mod tags are ignored when other vcs is declared, in any order
+		{"example.com/bar", "https://github.com/example/bar"},
+		{"example.com/baz/sub", "https://github.com/example/baz"},
+		// and used when it is the only one
+		{"example.com/mod", "https://proxy.example.com"},
+	}
+
+	for _, test := range tests {
+		imp, err := MatchImport(test.path, imports)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("MatchImport(%q) = %+v, want error", test.path, imp)
+			}
+			continue
+		}
+		if err != nil || imp.RepoRoot != test.want {
+			t.Errorf("MatchImport(%q) = %+v, %v; want %v", test.path, imp, err, test.want)
+		}
+	}
+
+	if _, err := MatchImport("example.com/foo", nil); err == nil {
+		t.Errorf("MatchImport without imports succeeded")
+	}
+}
+
+func TestGitHubRepo(t *testing.T) {
+	tests := []struct {
+		root string
+		want string
+		ok   bool
+	}{
+		{"https://github.com/uber-go/zap", "uber-go/zap", true},
+		{"https://github.com/Kubernetes/Client-Go.git", "kubernetes/client-go", true},
+		{"git@github.com:uber-go/zap/", "uber-go/zap", true},
+		{"https://github.com/uber-go", "", false},
+		{"https://github.com/uber-go/zap/sub", "", false},
+		{"https://gitlab.com/uber-go/zap", "", false},
+		{"https://proxy.golang.org", "", false},
+	}
+
+	for _, test := range tests {
+		got, ok := Import{RepoRoot: test.root}.GitHubRepo()
+		if got != test.want || ok != test.ok {
+			t.Errorf("GitHubRepo(%q) = %q, %v; want %q, %v", test.root, got, ok, test.want, test.ok)
+		}
+	}
+}
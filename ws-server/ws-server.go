@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"net/http"
 	"os"
@@ -36,11 +37,24 @@ var (
 			return false
 		},
 	}
+
+	memoryFile = flag.String("memory", "", "search repos loaded from JSON `file` of items kept in memory instead of PostgreSQL")
 )
 
 func main() {
 
-	utils.CheckEnvVars(true, true, false, true)
+	flag.Parse()
+
+	if *memoryFile != "" {
+		memory, err := database.LoadMemory(*memoryFile)
+		utils.HandleErrEXIT(err, "MEMORY STORAGE")
+		database.Store = memory
+
+		utils.CheckEnvVars(false, false, false, true)
+	} else {
+		utils.CheckEnvVars(true, true, false, true)
+		utils.HandleErrEXIT(database.CheckMigrations(), "DB MIGRATIONS")
+	}
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt)
@@ -82,7 +96,7 @@ func handleConn(conn *websocket.Conn) {
 		}
 
 		if string(msg) != "ping" {
-			data := database.Search(string(msg))
+			data := database.Search(string(msg), "", "")
 			if connErr := conn.WriteMessage(msgType, data); connErr != nil {
 				return
 			}